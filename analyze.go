@@ -0,0 +1,136 @@
+package lzss
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// CorpusReport summarizes how well a set of files compresses. Ratios are
+// compressed size over original size, so lower is better. Empty files are
+// counted in the totals but left out of the ratio statistics.
+type CorpusReport struct {
+	Files         int
+	TotalIn       int64
+	TotalOut      int64
+	BytesSaved    int64 // TotalIn - TotalOut, negative if the corpus expanded
+	MeanRatio     float64
+	MedianRatio   float64
+	BestRatio     float64
+	BestIndex     int // index of the best compressing file, -1 if none
+	WorstRatio    float64
+	WorstIndex    int // index of the worst compressing file, -1 if none
+	OverallRatio  float64
+	ExpandedFiles int // files whose compressed size exceeds the original
+}
+
+// AnalyzeCorpus compresses every file using up to workers goroutines and
+// reports aggregate statistics. Only the compressed sizes are kept, never the
+// compressed data itself.
+func AnalyzeCorpus(files [][]byte, workers int) CorpusReport {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sizes := make([]int, len(files))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sizes[i] = len(Compress(files[i]))
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	rep := CorpusReport{
+		Files:      len(files),
+		BestIndex:  -1,
+		WorstIndex: -1,
+	}
+
+	var ratios []float64
+	for i, dat := range files {
+		rep.TotalIn += int64(len(dat))
+		rep.TotalOut += int64(sizes[i])
+		if sizes[i] > len(dat) {
+			rep.ExpandedFiles++
+		}
+		if len(dat) == 0 {
+			continue
+		}
+		r := ratio(int64(len(dat)), int64(sizes[i]))
+		ratios = append(ratios, r)
+		if rep.BestIndex < 0 || r < rep.BestRatio {
+			rep.BestRatio, rep.BestIndex = r, i
+		}
+		if rep.WorstIndex < 0 || r > rep.WorstRatio {
+			rep.WorstRatio, rep.WorstIndex = r, i
+		}
+	}
+	rep.BytesSaved = rep.TotalIn - rep.TotalOut
+	rep.OverallRatio = ratio(rep.TotalIn, rep.TotalOut)
+
+	if len(ratios) > 0 {
+		var sum float64
+		for _, r := range ratios {
+			sum += r
+		}
+		rep.MeanRatio = sum / float64(len(ratios))
+
+		sort.Float64s(ratios)
+		if mid := len(ratios) / 2; len(ratios)%2 == 1 {
+			rep.MedianRatio = ratios[mid]
+		} else {
+			rep.MedianRatio = (ratios[mid-1] + ratios[mid]) / 2
+		}
+	}
+
+	return rep
+}
+
+// Ratio returns len(compressed)/len(original), so lower is better, or 0 when
+// original is empty.
+func Ratio(original, compressed []byte) float64 {
+	return ratio(int64(len(original)), int64(len(compressed)))
+}
+
+// ratio returns compressed/original, or 0 for empty input
+func ratio(original, compressed int64) float64 {
+	if original == 0 {
+		return 0
+	}
+	return float64(compressed) / float64(original)
+}
+
+// Entropy returns the Shannon entropy of src's byte frequencies in bits per
+// byte, from 0 for a single repeated value to 8 for uniformly random bytes, or
+// 0 when src is empty. It is a cheap hint of whether compressing is worth it:
+// data near 8 bits per byte will not shrink. It ignores byte order, so it
+// cannot see repeated strings that LZSS exploits; use CompressIfBeneficial for
+// a definite answer.
+func Entropy(src []byte) float64 {
+	if len(src) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, c := range src {
+		counts[c]++
+	}
+	var h float64
+	for _, k := range counts {
+		if k > 0 {
+			p := float64(k) / float64(len(src))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
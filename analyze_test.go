@@ -0,0 +1,36 @@
+package lzss
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	uniform := make([]byte, 256*64)
+	for i := range uniform {
+		uniform[i] = byte(i)
+	}
+	random := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name string
+		src  []byte
+		want float64
+		tol  float64
+	}{
+		{"empty", nil, 0, 0},
+		{"one byte", []byte{7}, 0, 0},
+		{"all same", bytes.Repeat([]byte{0x41}, 1000), 0, 0},
+		{"two values", []byte("abababab"), 1, 1e-12},
+		{"every value equally", uniform, 8, 1e-12},
+		{"random", random, 8, 0.01},
+	}
+	for _, tt := range tests {
+		if got := Entropy(tt.src); math.Abs(got-tt.want) > tt.tol {
+			t.Errorf("%s: Entropy = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
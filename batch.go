@@ -0,0 +1,56 @@
+package lzss
+
+import (
+	"fmt"
+	"sync"
+)
+
+// batchState is the encoder and decoder state reused across a batch
+type batchState struct {
+	window  []byte
+	finder  MatchFinder
+	scratch []byte
+}
+
+var batchPool = sync.Pool{
+	New: func() interface{} {
+		return &batchState{
+			window:  make([]byte, n+f-1),
+			finder:  NewTreeFinder(),
+			scratch: make([]byte, ScratchSize),
+		}
+	},
+}
+
+// CompressBatch compresses each of msgs like Compress. The ring buffer and
+// match finder are set up once and reused for every message, which saves most
+// of the per-call cost when the messages are small.
+func CompressBatch(msgs [][]byte, opts ...Option) [][]byte {
+	o := mustOptions(opts)
+	bs := batchPool.Get().(*batchState)
+	defer batchPool.Put(bs)
+
+	o.window = bs.window
+	dst := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		dst[i] = compressBytes(msg, o, bs.finder, nil)
+	}
+	return dst
+}
+
+// DecompressBatch decompresses each of msgs, reusing one ring buffer for all
+// of them. It stops at the first message that fails to decode.
+func DecompressBatch(msgs [][]byte) ([][]byte, error) {
+	bs := batchPool.Get().(*batchState)
+	defer batchPool.Put(bs)
+
+	dst := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		dat, err := DecompressWith(bs.scratch, msg)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		dst[i] = dat
+	}
+	return dst, nil
+}
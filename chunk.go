@@ -0,0 +1,81 @@
+package lzss
+
+import "fmt"
+
+// ChunkConfig configures ChunkBoundaries. Zero fields take the defaults.
+type ChunkConfig struct {
+	// MinSize is the smallest chunk, except for the last (default 2 KiB)
+	MinSize int
+	// AvgSize is the expected chunk size past MinSize, rounded up to a power of two (default 8 KiB)
+	AvgSize int
+	// MaxSize is the largest chunk; a boundary is forced there (default 64 KiB)
+	MaxSize int
+	// Seed picks the rolling hash's byte table, so boundaries differ per seed
+	Seed uint64
+}
+
+// ChunkBoundaries splits data into content-defined chunks for deduplication
+// and returns the end offset of each chunk, the last being len(data). A
+// rolling gear hash over roughly the last 64 bytes ends a chunk wherever its
+// low bits are zero, so an insertion or deletion only moves the boundaries
+// near it and identical content elsewhere still yields identical chunks. The
+// chunks can be compressed one by one, or the offsets passed to
+// WithRecordBoundaries.
+func ChunkBoundaries(data []byte, cfg ChunkConfig) ([]int, error) {
+	if cfg.MinSize == 0 {
+		cfg.MinSize = 2 << 10
+	}
+	if cfg.AvgSize == 0 {
+		cfg.AvgSize = 8 << 10
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = 64 << 10
+	}
+	if cfg.MinSize < 1 || cfg.MinSize > cfg.AvgSize || cfg.AvgSize > cfg.MaxSize {
+		return nil, fmt.Errorf("chunk sizes must satisfy 1 <= min (%d) <= avg (%d) <= max (%d)", cfg.MinSize, cfg.AvgSize, cfg.MaxSize)
+	}
+
+	mask := uint64(1)
+	for mask < uint64(cfg.AvgSize) {
+		mask <<= 1
+	}
+	mask--
+	// use the top bits of the hash, which depend on the most input bytes
+	for mask&(1<<63) == 0 {
+		mask <<= 1
+	}
+
+	gear := gearTable(cfg.Seed)
+
+	var ends []int
+	start := 0
+	var h uint64
+	for i, c := range data {
+		h = h<<1 + gear[c]
+		size := i + 1 - start
+		if size >= cfg.MaxSize || size >= cfg.MinSize && h&mask == 0 {
+			ends = append(ends, i+1)
+			start = i + 1
+			h = 0
+		}
+	}
+	if start < len(data) {
+		ends = append(ends, len(data))
+	}
+	return ends, nil
+}
+
+// gearTable returns the rolling hash's random value for each byte, derived
+// from seed with splitmix64
+func gearTable(seed uint64) *[256]uint64 {
+	var t [256]uint64
+	x := seed
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return &t
+}
@@ -0,0 +1,214 @@
+// Command lzss compresses and decompresses files with the lzss package.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/blacktop/lzss"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command with args, not including the program name, and
+// returns its exit code: 0 on success, 1 on failure and 2 on bad usage.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("lzss", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	decompress := fs.Bool("d", false, "decompress instead of compress")
+	output := fs.String("o", "", "output file (default: input with .lzss added or removed)")
+	tokens := fs.Bool("tokens", false, "print the token breakdown of a compressed file")
+	mode := fs.String("mode", "", "octal permissions of the output file (default: those of the input)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: lzss [flags] <file>\n\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *decompress && *tokens {
+		fmt.Fprintf(stderr, "lzss: -d and -tokens cannot be used together\n")
+		return 2
+	}
+	// nil keeps the input's permissions; -mode 0 is a valid mode
+	var perm *os.FileMode
+	if *mode != "" {
+		m, err := strconv.ParseUint(*mode, 8, 32)
+		if err != nil || m > 0777 {
+			fmt.Fprintf(stderr, "lzss: invalid -mode %q, want octal permissions such as 0644\n", *mode)
+			return 2
+		}
+		fm := os.FileMode(m)
+		perm = &fm
+	}
+
+	var err error
+	if *tokens {
+		err = printTokens(stdout, fs.Arg(0))
+	} else {
+		err = convert(fs.Arg(0), *output, *decompress, perm)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "lzss: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// convert compresses or decompresses the file in to out, picking a name for
+// out from in when it is empty. out gets the permissions *perm, or those of in
+// when perm is nil. Both sides are streamed, so neither file is held in memory.
+func convert(in, out string, decompress bool, perm *os.FileMode) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	defer f.Close()
+	var mode os.FileMode
+	if perm != nil {
+		mode = *perm
+	} else {
+		fi, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		mode = fi.Mode().Perm()
+	}
+
+	if out == "" {
+		if decompress {
+			out = strings.TrimSuffix(in, ".lzss")
+			if out == in {
+				out = in + ".decompressed"
+			}
+		} else {
+			out = in + ".lzss"
+		}
+	}
+	if out == in {
+		return fmt.Errorf("output %s would overwrite the input", out)
+	}
+
+	w, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	bw := bufio.NewWriter(w)
+	if decompress {
+		err = decompressTo(bw, f)
+	} else {
+		_, err = lzss.CompressStream(bw, f)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := w.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to write output: %w", cerr)
+	}
+	if err != nil {
+		// do not leave partial or unverified output behind
+		os.Remove(out)
+		return err
+	}
+
+	// OpenFile leaves the mode of an existing file alone and applies the umask
+	if err := os.Chmod(out, mode); err != nil {
+		return fmt.Errorf("failed to set output permissions: %w", err)
+	}
+	return nil
+}
+
+// decompressTo decodes the raw lzss or complzss data in r to w as it is read
+func decompressTo(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(8)
+	var err error
+	if lzss.DetectFormat(magic) == lzss.FormatComplzss {
+		_, err = lzss.DecompressFileStream(w, br)
+	} else {
+		_, err = lzss.DecompressStream(w, br)
+	}
+	return err
+}
+
+// stripHeader returns the lzss data of a complzss file, or dat unchanged
+func stripHeader(dat []byte) ([]byte, error) {
+	if lzss.DetectFormat(dat) != lzss.FormatComplzss {
+		return dat, nil
+	}
+	hdr, err := lzss.ParseHeaderAt(dat, 0)
+	if err != nil {
+		return nil, err
+	}
+	dat = dat[binary.Size(hdr):]
+	if int(hdr.CompressedSize) <= len(dat) {
+		dat = dat[:hdr.CompressedSize]
+	}
+	return dat, nil
+}
+
+// printTokens writes a summary of the literal and match tokens in the
+// compressed file in
+func printTokens(w io.Writer, in string) error {
+	dat, err := ioutil.ReadFile(in)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	if dat, err = stripHeader(dat); err != nil {
+		return err
+	}
+
+	var literals, matches, matched int
+	// hist[i] counts matches with a distance in [1<<i, 1<<(i+1))
+	var hist [12]int
+
+	t := lzss.NewTokenReader(dat)
+	for {
+		ev, err := t.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read tokens: %w", err)
+		}
+		if !ev.IsMatch() {
+			literals++
+			continue
+		}
+		matches++
+		matched += ev.Match.Length
+		for i := len(hist) - 1; i >= 0; i-- {
+			if ev.Distance() >= 1<<i {
+				hist[i]++
+				break
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "literals:             %d\n", literals)
+	fmt.Fprintf(w, "matches:              %d\n", matches)
+	if matches > 0 {
+		fmt.Fprintf(w, "average match length: %.2f\n", float64(matched)/float64(matches))
+	}
+	fmt.Fprintf(w, "distance histogram:\n")
+	for i, c := range hist {
+		fmt.Fprintf(w, "  %4d-%-4d %d\n", 1<<i, 1<<(i+1)-1, c)
+	}
+	return nil
+}
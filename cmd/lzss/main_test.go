@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lzss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.txt")
+	if err := ioutil.WriteFile(in, []byte("hello hello hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"help", []string{"-h"}, 0, "usage: lzss"},
+		{"no file", nil, 2, "usage: lzss"},
+		{"two files", []string{in, in}, 2, "usage: lzss"},
+		{"unknown flag", []string{"-x", in}, 2, "flag provided but not defined"},
+		{"decompress and tokens", []string{"-d", "-tokens", in}, 2, "-d and -tokens cannot be used together"},
+		{"mode not octal", []string{"-mode", "rw", in}, 2, `invalid -mode "rw"`},
+		{"mode too large", []string{"-mode", "1777", in}, 2, `invalid -mode "1777"`},
+		{"missing input", []string{filepath.Join(dir, "missing")}, 1, "failed to read input"},
+		{"output is input", []string{"-o", in, in}, 1, "would overwrite the input"},
+		{"tokens of missing input", []string{"-tokens", filepath.Join(dir, "missing")}, 1, "lzss: "},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := run(tt.args, &stdout, &stderr); code != tt.code {
+			t.Errorf("%s: exit code %d, want %d; stderr: %s", tt.name, code, tt.code, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("%s: stderr %q, want it to contain %q", tt.name, stderr.String(), tt.stderr)
+		}
+	}
+}
+
+func TestRunRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lzss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.txt")
+	want := bytes.Repeat([]byte("hello lzss "), 100)
+	if err := ioutil.WriteFile(in, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-mode", "0600", in}, &stdout, &stderr); code != 0 {
+		t.Fatalf("compress: exit code %d; stderr: %s", code, stderr.String())
+	}
+	fi, err := os.Stat(in + ".lzss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("compressed file mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	out := filepath.Join(dir, "out.txt")
+	if code := run([]string{"-d", "-o", out, in + ".lzss"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("decompress: exit code %d; stderr: %s", code, stderr.String())
+	}
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("round trip returned %d bytes, want %d", len(got), len(want))
+	}
+	if fi, err = os.Stat(out); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("decompressed file mode %v, want the input's 0600", fi.Mode().Perm())
+	}
+}
+
+func TestRunModeZero(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lzss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.txt")
+	if err := ioutil.WriteFile(in, []byte("hello hello hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0 is a mode like any other, not a request for the input's
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-mode", "0", in}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d; stderr: %s", code, stderr.String())
+	}
+	fi, err := os.Stat(in + ".lzss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0 {
+		t.Errorf("compressed file mode %v, want 0000", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,19 @@
+/*
+Package compat provides Compress and Decompress with the ([]byte, error)
+signatures used by other lzss forks, so code written against them can switch
+to package lzss by changing an import path. New code should use package lzss
+directly.
+*/
+package compat
+
+import "github.com/blacktop/lzss"
+
+// Compress compresses src with the default options
+func Compress(src []byte) ([]byte, error) {
+	return lzss.CompressWithOptions(src, lzss.DefaultOptions())
+}
+
+// Decompress decompresses lzss data, returning an error if it is truncated
+func Decompress(src []byte) ([]byte, error) {
+	return lzss.DecompressWith(nil, src)
+}
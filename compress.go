@@ -0,0 +1,876 @@
+package lzss
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// footerSize is the size of the optional uncompressed length footer
+const footerSize = 4
+
+const (
+	// minLiteralRun is the fewest literals sent as a run token
+	minLiteralRun = 26
+	// maxLiteralRun is the most literals one run token holds
+	maxLiteralRun = 256
+)
+
+type encodeState struct {
+	// ring buffer of size n, with extra f-1 bytes to aid string comparison
+	textBuf []byte
+	// finder keeps track of the strings in textBuf
+	finder MatchFinder
+	// match is the longest match for the string at r, as returned by
+	// the finder when r was inserted.
+	match Match
+
+	// s is where the next input byte goes and r is the start of the
+	// lookahead, which holds dataLen bytes starting at input offset.
+	s, r, dataLen, offset int
+	in                    *encoderInput
+
+	// the record containing offset, see WithRecordBoundaries
+	recordStart, nextRecord int
+}
+
+// initState sets up the ring buffer and the match finder, using the binary
+// search trees when finder is nil. textBuf is cleared and reused as the ring
+// buffer if it is big enough.
+func initState(finder MatchFinder, textBuf []byte) *encodeState {
+	if finder == nil {
+		finder = NewTreeFinder()
+	}
+	if len(textBuf) < n+f-1 {
+		textBuf = make([]byte, n+f-1)
+	} else {
+		textBuf = textBuf[:n+f-1]
+		for i := range textBuf {
+			textBuf[i] = 0
+		}
+	}
+	sp := &encodeState{
+		textBuf: textBuf,
+		finder:  finder,
+	}
+	finder.Reset(sp.textBuf)
+	return sp
+}
+
+// insert registers the string at r with the finder
+func (sp *encodeState) insert(r int) {
+	sp.match = sp.finder.Insert(r)
+}
+
+// isRun reports whether the f bytes at r repeat the byte just before r
+func (sp *encodeState) isRun(r int) bool {
+	c := sp.textBuf[(r-1)&(n-1)]
+	for _, b := range sp.textBuf[r : r+f] {
+		if b != c {
+			return false
+		}
+	}
+	return true
+}
+
+// Match is a back-reference: Length bytes copied from ring buffer Position
+type Match struct {
+	Position int
+	Length   int
+}
+
+// Event is a single encoder decision, either a literal byte or a Match
+type Event struct {
+	Offset  int   // offset in the uncompressed input the token starts at
+	Literal byte  // the byte sent as a literal, when Match.Length is 0
+	Match   Match // the back-reference sent, when Match.Length is non-zero
+}
+
+// IsMatch reports whether e is a back-reference rather than a literal
+func (e Event) IsMatch() bool {
+	return e.Match.Length > 0
+}
+
+// Distance returns how many bytes back from e.Offset a match copies from, or 0
+// for a literal.
+func (e Event) Distance() int {
+	if !e.IsMatch() {
+		return 0
+	}
+	return (n - f + e.Offset - e.Match.Position) & (n - 1)
+}
+
+// Compress compresses src using lzss
+func Compress(src []byte, opts ...Option) []byte {
+	return compressBytes(src, mustOptions(opts), nil, nil)
+}
+
+// CompressToBuffer compresses src like Compress, appending the output to dst
+// rather than a new buffer, and returns the number of bytes appended. Any
+// WithOutputAlignment padding aligns the appended bytes, not all of dst.
+func CompressToBuffer(dst *bytes.Buffer, src []byte, opts ...Option) int {
+	o := mustOptions(opts)
+	dst.Grow(o.capacity(len(src) / 2))
+	// writes to a bytes.Buffer cannot fail
+	written, _ := compress(dst, bytes.NewReader(src), o, nil, nil)
+	return int(written)
+}
+
+// CompressWithOptions compresses src with o, such as a modified DefaultOptions,
+// and returns an error rather than panicking if o is invalid.
+func CompressWithOptions(src []byte, o Options) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	return compressBytes(src, o, nil, nil), nil
+}
+
+// CompressTrace compresses src like Compress and also returns every literal
+// and match decision the encoder made, in stream order.
+func CompressTrace(src []byte, opts ...Option) ([]byte, []Event) {
+	var events []Event
+	dst := compressBytes(src, mustOptions(opts), nil, &events)
+	return dst, events
+}
+
+// CompareTraces checks that two encoders made the same parse, given the events
+// of each from CompressTrace or an equivalent, and describes the first
+// difference otherwise. It is meant for testing a new or optimized encoder
+// against the reference one with the same options: the same parse means the
+// same compressed bytes and ratio.
+func CompareTraces(want, got []Event) error {
+	for i := 0; i < len(want) && i < len(got); i++ {
+		if want[i] != got[i] {
+			return fmt.Errorf("parse differs at token %d: want %s, got %s", i, traceString(want[i]), traceString(got[i]))
+		}
+	}
+	if len(want) != len(got) {
+		return fmt.Errorf("parse has %d tokens, want %d", len(got), len(want))
+	}
+	return nil
+}
+
+// traceString describes ev for CompareTraces
+func traceString(ev Event) string {
+	if !ev.IsMatch() {
+		return fmt.Sprintf("literal %#02x at offset %d", ev.Literal, ev.Offset)
+	}
+	return fmt.Sprintf("match distance %d length %d at offset %d", ev.Distance(), ev.Match.Length, ev.Offset)
+}
+
+// Stats describes the result of a compression
+type Stats struct {
+	InputSize  int
+	OutputSize int
+	Literals   int     // literal tokens sent
+	Matches    int     // match tokens sent
+	Ratio      float64 // OutputSize / InputSize, 0 for empty input
+	// Expanded is set when the output is larger than the input, in which
+	// case storing the input uncompressed would be smaller.
+	Expanded bool
+}
+
+// CompressStats compresses src like Compress and also reports statistics,
+// including whether the data expanded instead of shrinking.
+func CompressStats(src []byte, opts ...Option) ([]byte, Stats) {
+	var stats Stats
+	o := mustOptions(opts)
+	o.stats = &stats
+	dst := compressBytes(src, o, nil, nil)
+	return dst, stats
+}
+
+// beneficialSample is how much input CompressIfBeneficial reads before it
+// starts checking the running ratio
+const beneficialSample = 64 << 10
+
+// errNotBeneficial stops a CompressIfBeneficial encode early
+var errNotBeneficial = errors.New("compression ratio above limit")
+
+// ratioWriter is a bytes.Buffer that fails once the output grows past maxRatio
+// times the input read so far
+type ratioWriter struct {
+	bytes.Buffer
+	in       *bytes.Reader
+	size     int
+	maxRatio float64
+}
+
+func (rw *ratioWriter) Write(p []byte) (int, error) {
+	read := rw.size - rw.in.Len()
+	if read >= beneficialSample && float64(rw.Len()+len(p)) > rw.maxRatio*float64(read) {
+		return 0, errNotBeneficial
+	}
+	return rw.Buffer.Write(p)
+}
+
+// CompressIfBeneficial compresses src like Compress if the result is at most
+// maxRatio times the size of src, and returns nil and false otherwise. To save
+// work on incompressible data it gives up as soon as the output so far exceeds
+// maxRatio times the input read so far. The first 64 KiB of input are always
+// compressed before checking, since the window starts empty and early output
+// is mostly literals; data that only starts compressing well later on may
+// still be rejected, so the check is a heuristic.
+func CompressIfBeneficial(src []byte, maxRatio float64, opts ...Option) ([]byte, bool) {
+	o := mustOptions(opts)
+	in := bytes.NewReader(src)
+	rw := &ratioWriter{in: in, size: len(src), maxRatio: maxRatio}
+
+	written, err := compress(rw, in, o, nil, nil)
+	if err != nil || float64(written) > maxRatio*float64(len(src)) {
+		return nil, false
+	}
+	return rw.Bytes(), true
+}
+
+// CompressWithFinder compresses src like Compress, using finder to look for
+// matches instead of the default binary search trees.
+func CompressWithFinder(src []byte, finder MatchFinder, opts ...Option) []byte {
+	return compressBytes(src, mustOptions(opts), finder, nil)
+}
+
+// MatchHook supplies matches from outside the encoder, such as an external
+// index. It is called with the input offset the encoder is at and returns how
+// many bytes back a match starts and its length, or a length of 0 to let the
+// match finder decide.
+type MatchHook func(offset int) (distance, length int)
+
+// CompressWithHook compresses src like Compress, but asks hook for a match at
+// every position first. The encoder checks a hooked match against the data
+// and shortens it to the part that really matches, so a wrong hook can cost
+// ratio but never corrupt the stream. Matches are limited to f bytes and to
+// the n-f bytes before the current position, and still obey the options.
+func CompressWithHook(src []byte, hook MatchHook, opts ...Option) []byte {
+	o := mustOptions(opts)
+	o.hook = hook
+	return compressBytes(src, o, nil, nil)
+}
+
+// hookMatch asks hook for a match at r and returns the part of it that is valid
+func (sp *encodeState) hookMatch(hook MatchHook) (Match, bool) {
+	dist, length := hook(sp.offset)
+	if dist < 1 || dist > sp.offset || dist > n-f || length <= threshold {
+		return Match{}, false
+	}
+	if length > f {
+		length = f
+	}
+	if length > sp.dataLen {
+		length = sp.dataLen
+	}
+	p := (sp.r - dist) & (n - 1)
+	k := 0
+	for k < length && sp.textBuf[(p+k)&(n-1)] == sp.textBuf[(sp.r+k)&(n-1)] {
+		k++
+	}
+	if k <= threshold {
+		return Match{}, false
+	}
+	return Match{Position: p, Length: k}, true
+}
+
+// CompressStream compresses everything read from src to dst using a fixed
+// amount of memory, no matter how long src is. It returns the number of bytes
+// written to dst. Output is buffered unless WithGroupFlush is given.
+func CompressStream(dst io.Writer, src io.Reader, opts ...Option) (int64, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	if o.FlushGroups {
+		return compress(dst, bufio.NewReader(src), o, nil, nil)
+	}
+	bw := bufio.NewWriter(dst)
+	written, err := compress(bw, bufio.NewReader(src), o, nil, nil)
+	if err != nil {
+		return written, err
+	}
+	return written, bw.Flush()
+}
+
+// EncoderMemoryBytes returns the bytes of buffers CompressStream allocates
+// for the given options: the ring buffer, the binary search trees, the input
+// and output buffering, and any buffers of WithLiteralRuns and Optimal
+// parsing. It does not depend on the input length, as the encoder's memory
+// is fixed however much data streams through it. The figure is what the
+// encoder asks for; the Go allocator rounds large buffers up to whole pages,
+// and a few hundred bytes of bookkeeping structs are not counted.
+func EncoderMemoryBytes(opts ...Option) int {
+	o := mustOptions(opts)
+	const intSize = strconv.IntSize / 8
+
+	size := n + f - 1             // ring buffer
+	size += (3*n + 259) * intSize // tree children and parents
+	size += 2 * 4096              // bufio reader and writer
+	size += 1 + 8*2               // flag byte group
+	if o.LiteralRuns {
+		// held back literals, and groups of eight full runs
+		size += maxLiteralRun + 8*(3+maxLiteralRun) - 8*2
+	}
+	if o.Parsing == Optimal {
+		// matches, literals, costs and lengths of a block
+		size += optimalBlock * (2*intSize + 1 + intSize + intSize)
+		size += intSize
+	}
+	return size
+}
+
+// CompressTo compresses src to w as the output is produced, without holding
+// it in memory, and returns the number of bytes written and the CRC-32 (IEEE)
+// of those bytes. The CRC covers the compressed data, for checking it in
+// transit; it is unrelated to the Adler-32 of a complzss Header.
+func CompressTo(w io.Writer, src []byte, opts ...Option) (int, uint32, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, 0, err
+	}
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	written, err := compress(bw, bytes.NewReader(src), o, nil, nil)
+	if err == nil {
+		err = bw.Flush()
+	}
+	return int(written), crc.Sum32(), err
+}
+
+func compressBytes(src []byte, o Options, finder MatchFinder, events *[]Event) []byte {
+	dst := bytes.Buffer{}
+	dst.Grow(o.capacity(len(src) / 2))
+	// neither side of an in-memory compress can fail
+	compress(&dst, bytes.NewReader(src), o, finder, events)
+	return dst.Bytes()
+}
+
+// encoderInput reads the encoder's input, counting bytes and keeping the first error
+type encoderInput struct {
+	r   io.ByteReader
+	n   int
+	err error
+}
+
+func (in *encoderInput) readByte() (byte, bool) {
+	if in.err != nil {
+		return 0, false
+	}
+	c, err := in.r.ReadByte()
+	if err != nil {
+		in.err = err
+		return 0, false
+	}
+	in.n++
+	return c, true
+}
+
+// countWriter counts the bytes written to w and keeps the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// tokenWriter packs tokens into groups of eight behind a flag byte
+type tokenWriter struct {
+	out *countWriter
+
+	// codeBuf[1:] saves eight units of code, and codeBuf[0] works as
+	// eight flags, "1" representing that the unit is an unencoded letter
+	// (1 byte), "0" a position-and-length pair (2 bytes).
+	// Thus, eight units require at most 16 bytes of code, unless they
+	// include literal runs.
+	codeBuf  []byte
+	mask     byte
+	groups   int  // flag bytes written so far
+	zeros    bool // the last group written was all zero bytes
+	literals int
+	matches  int
+
+	packing Packing
+	invert  bool // write flag bytes inverted
+	// run holds back literals to send as runs, see WithLiteralRuns
+	runs   bool
+	run    []byte
+	events *[]Event
+	logger *log.Logger
+	offset int // input offset of the next token
+}
+
+func newTokenWriter(dst io.Writer, o *Options, events *[]Event) *tokenWriter {
+	return &tokenWriter{
+		out:     &countWriter{w: dst},
+		codeBuf: make([]byte, 1, 17),
+		mask:    1,
+		packing: o.Packing,
+		invert:  o.InvertFlags,
+		runs:    o.LiteralRuns,
+		events:  events,
+		logger:  o.Logger,
+	}
+}
+
+// literal sends one byte
+func (tw *tokenWriter) literal(c byte) error {
+	tw.literals++
+	if tw.events != nil {
+		*tw.events = append(*tw.events, Event{Offset: tw.offset, Literal: c})
+	}
+	if tw.logger != nil {
+		tw.logger.Printf("%d: literal %#02x", tw.offset, c)
+	}
+	tw.offset++
+	if tw.runs {
+		tw.run = append(tw.run, c)
+		// a full run goes out now, keeping the held back literals bounded
+		if len(tw.run) == maxLiteralRun {
+			return tw.sendRun()
+		}
+		return nil
+	}
+	return tw.putLiteral(c)
+}
+
+// putLiteral packs the literal c into the code buffer
+func (tw *tokenWriter) putLiteral(c byte) error {
+	tw.codeBuf[0] |= tw.mask // 'send one byte' flag
+	tw.codeBuf = append(tw.codeBuf, c)
+	return tw.next()
+}
+
+// sendRun sends the literals held back by WithLiteralRuns: as run tokens of
+// up to maxLiteralRun bytes when there are at least minLiteralRun of them,
+// since a run token costs three bytes, and one by one otherwise.
+func (tw *tokenWriter) sendRun() error {
+	lits := tw.run
+	tw.run = tw.run[:0]
+	for len(lits) >= minLiteralRun {
+		k := len(lits)
+		if k > maxLiteralRun {
+			k = maxLiteralRun
+		}
+		start := tw.offset - len(lits)
+		tw.put(Match{Position: (n - f + start) & (n - 1), Length: threshold + 2})
+		tw.codeBuf = append(tw.codeBuf, byte(k-1))
+		tw.codeBuf = append(tw.codeBuf, lits[:k]...)
+		lits = lits[k:]
+		if err := tw.next(); err != nil {
+			return err
+		}
+	}
+	for _, c := range lits {
+		if err := tw.putLiteral(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match sends a position and length pair. Note m.Length > threshold.
+func (tw *tokenWriter) match(m Match) error {
+	tw.matches++
+	if err := tw.sendRun(); err != nil {
+		return err
+	}
+	tw.put(m)
+	if tw.events != nil {
+		*tw.events = append(*tw.events, Event{Offset: tw.offset, Match: m})
+	}
+	if tw.logger != nil {
+		ev := Event{Offset: tw.offset, Match: m}
+		tw.logger.Printf("%d: match distance %d length %d", tw.offset, ev.Distance(), m.Length)
+	}
+	tw.offset += m.Length
+	return tw.next()
+}
+
+// put packs the match m into the code buffer
+func (tw *tokenWriter) put(m Match) {
+	var hi byte
+	if tw.packing == PackLengthHigh {
+		hi = byte(((m.Length - (threshold + 1)) << 4) | ((m.Position >> 8) & 0x0F))
+	} else {
+		hi = byte(((m.Position >> 4) & 0xF0) | (m.Length - (threshold + 1)))
+	}
+	tw.codeBuf = append(tw.codeBuf, byte(m.Position), hi)
+}
+
+// endMarker sends the end of stream marker: a match that copies from the
+// position being written, which an encoder never otherwise sends.
+func (tw *tokenWriter) endMarker() error {
+	tw.put(Match{Position: (n - f + tw.offset) & (n - 1), Length: threshold + 1})
+	return tw.next()
+}
+
+// next shifts the flag mask, sending the group once it holds 8 units
+func (tw *tokenWriter) next() error {
+	if tw.mask <<= 1; tw.mask == 0 {
+		return tw.flush()
+	}
+	return nil
+}
+
+// flush sends the remaining code
+func (tw *tokenWriter) flush() error {
+	if len(tw.codeBuf) > 1 {
+		if tw.invert {
+			tw.codeBuf[0] ^= 0xFF
+		}
+		if _, err := tw.out.Write(tw.codeBuf); err != nil {
+			return err
+		}
+		tw.groups++
+		tw.zeros = zeroTail(tw.codeBuf) == 0
+	}
+	tw.codeBuf = tw.codeBuf[:1]
+	tw.codeBuf[0] = 0
+	tw.mask = 1
+	return nil
+}
+
+// appendFooter appends the WithLengthFooter footer recording size to dst
+func appendFooter(dst []byte, size int) []byte {
+	var footer [footerSize]byte
+	binary.BigEndian.PutUint32(footer[:], uint32(size))
+	return append(dst, footer[:]...)
+}
+
+// compress encodes src to dst using finder (nil for the default), appending
+// each token to events when it is non-nil, and returns the number of bytes
+// written.
+func compress(dst io.Writer, src io.ByteReader, o Options, finder MatchFinder, events *[]Event) (int64, error) {
+	var start time.Time
+	if o.Metrics != nil {
+		start = time.Now()
+	}
+
+	sp := initState(finder, o.window)
+	sp.in = &encoderInput{r: src}
+	tw := newTokenWriter(dst, &o, events)
+	out := tw.out
+
+	sp.fill(o.RingSnapshot)
+
+	var err error
+	switch o.Parsing {
+	case Lazy:
+		err = sp.parseLazy(tw, &o)
+	case Optimal:
+		err = sp.parseOptimal(tw, &o)
+	default:
+		err = sp.parseGreedy(tw, &o)
+	}
+	if err != nil {
+		return out.n, err
+	}
+
+	if sp.in.err != io.EOF {
+		return out.n, fmt.Errorf("failed to read input: %w", sp.in.err)
+	}
+
+	if err := tw.sendRun(); err != nil {
+		return out.n, err
+	}
+	// a stream ending in a group of zeros, only possible as eight zero
+	// literals with WithInvertedFlags, reads as padding when aligned, so
+	// end it with a marker instead
+	if o.EndMarker || o.Alignment > 1 && len(tw.codeBuf) == 1 && tw.zeros {
+		if err := tw.endMarker(); err != nil {
+			return out.n, err
+		}
+	}
+	if err := tw.flush(); err != nil {
+		return out.n, err
+	}
+
+	if o.Alignment > 1 {
+		size := out.n
+		if o.LengthFooter {
+			size += footerSize
+		}
+		out.Write(alignPadding(size, o.Alignment))
+	}
+
+	if o.LengthFooter {
+		out.Write(appendFooter(nil, sp.in.n))
+	}
+
+	if out.err != nil {
+		return out.n, out.err
+	}
+
+	if o.Metrics != nil {
+		o.Metrics.record(sp.in.n, int(out.n), tw.groups, start)
+	}
+
+	if o.stats != nil {
+		*o.stats = Stats{
+			InputSize:  sp.in.n,
+			OutputSize: int(out.n),
+			Literals:   tw.literals,
+			Matches:    tw.matches,
+			Ratio:      ratio(int64(sp.in.n), out.n),
+			Expanded:   out.n > int64(sp.in.n),
+		}
+	}
+
+	return out.n, nil
+}
+
+// fill reads the first f bytes of input into the last f bytes of the buffer
+func (sp *encodeState) fill(snapshot []byte) {
+	sp.s = 0
+	sp.r = n - f
+
+	for sp.dataLen = 0; sp.dataLen < f; sp.dataLen++ {
+		c, ok := sp.in.readByte()
+		if !ok {
+			break
+		}
+		sp.textBuf[sp.r+sp.dataLen] = c
+	}
+
+	// The space-filled region before r is deliberately left out of the
+	// trees, so the output never references it and decodes the same no
+	// matter what a decoder pre-fills its ring buffer with. A snapshot
+	// given with WithRingSnapshot goes there instead, and is matched.
+	if sp.dataLen > 0 {
+		start := sp.r - len(snapshot)
+		copy(sp.textBuf[start:], snapshot)
+		for p := start; p < sp.r; p++ {
+			if p < f-1 {
+				sp.textBuf[p+n] = sp.textBuf[p]
+			}
+			sp.insert(p)
+		}
+		sp.insert(sp.r)
+	}
+}
+
+// advance slides the window k bytes forward, reading more input into the
+// lookahead. Inside a run only the last position is put into the trees.
+func (sp *encodeState) advance(k int, run bool) {
+	var i int
+	for i = 0; i < k; i++ {
+		c, ok := sp.in.readByte()
+		if !ok {
+			break
+		}
+		sp.finder.Remove(sp.s) // Delete old strings and
+		sp.textBuf[sp.s] = c
+		// If the position is near the end of buffer, extend the buffer
+		// to make string comparison easier.
+		if sp.s < f-1 {
+			sp.textBuf[sp.s+n] = c
+		}
+		// Since this is a ring buffer, increment the position modulo n.
+		sp.s = (sp.s + 1) & (n - 1)
+		sp.r = (sp.r + 1) & (n - 1)
+		// Register the string in textBuf[r..r+f-1]
+		if !run || i == k-1 {
+			sp.insert(sp.r)
+		}
+	}
+	for ; i < k; i++ {
+		// After the end of text, no need to read,
+		sp.finder.Remove(sp.s)
+		sp.s = (sp.s + 1) & (n - 1)
+		sp.r = (sp.r + 1) & (n - 1)
+		// but buffer may not be empty.
+		sp.dataLen--
+		if sp.dataLen > 0 && (!run || i == k-1) {
+			sp.insert(sp.r)
+		}
+	}
+	sp.offset += k
+}
+
+// findMatch returns the match to send at r once the encoder options have been
+// applied, and whether it covers a run of one byte value. A Length of
+// threshold or less means a literal must be sent instead.
+func (sp *encodeState) findMatch(o *Options) (Match, bool) {
+	m := sp.match
+
+	// Fast path for runs of one byte value, such as the zero fill of
+	// sparse firmware images: send a distance-1 match of length f and
+	// skip the tree work for the positions inside the run.
+	run := sp.offset > 0 && sp.dataLen >= f && sp.isRun(sp.r)
+	if run {
+		m = Match{Position: (sp.r - 1) & (n - 1), Length: f}
+	}
+
+	if o.hook != nil {
+		if hm, ok := sp.hookMatch(o.hook); ok {
+			m, run = hm, false
+		}
+	}
+
+	// matchLength may be spuriously long near the end of text.
+	if m.Length > sp.dataLen {
+		m.Length = sp.dataLen
+	}
+	if o.LiteralsOnly || o.MaxDistance > 0 && (sp.r-m.Position)&(n-1) > o.MaxDistance {
+		m.Length = 0
+	}
+	if len(o.RecordBoundaries) > 0 {
+		// keep matches inside the record that contains offset
+		for sp.nextRecord < len(o.RecordBoundaries) && o.RecordBoundaries[sp.nextRecord] <= sp.offset {
+			sp.recordStart = o.RecordBoundaries[sp.nextRecord]
+			sp.nextRecord++
+		}
+		if sp.offset-(sp.r-m.Position)&(n-1) < sp.recordStart {
+			m.Length = 0
+		} else if sp.nextRecord < len(o.RecordBoundaries) && sp.offset+m.Length > o.RecordBoundaries[sp.nextRecord] {
+			m.Length = o.RecordBoundaries[sp.nextRecord] - sp.offset
+		}
+	}
+	if m.Length < o.MinMatch || o.Alignment > 1 && zeroMatch(m.Position, m.Length) {
+		m.Length = 0
+	}
+
+	return m, run
+}
+
+// parseGreedy always sends the longest match available
+func (sp *encodeState) parseGreedy(tw *tokenWriter, o *Options) error {
+	for sp.dataLen > 0 {
+		m, run := sp.findMatch(o)
+		if err := sp.send(tw, &m); err != nil {
+			return err
+		}
+		sp.advance(m.Length, run)
+	}
+	return nil
+}
+
+// parseLazy looks one byte ahead before sending a match, and sends a literal
+// instead if a longer match starts at the next byte.
+func (sp *encodeState) parseLazy(tw *tokenWriter, o *Options) error {
+	m, run := sp.findMatch(o)
+	for sp.dataLen > 0 {
+		if m.Length > threshold && m.Length < f && !run && sp.dataLen > 1 {
+			c := sp.textBuf[sp.r]
+			sp.advance(1, false)
+			next, nextRun := sp.findMatch(o)
+			if next.Length > m.Length {
+				if err := tw.literal(c); err != nil {
+					return err
+				}
+				m, run = next, nextRun
+				continue
+			}
+			if err := tw.match(m); err != nil {
+				return err
+			}
+			sp.advance(m.Length-1, false)
+		} else {
+			if err := sp.send(tw, &m); err != nil {
+				return err
+			}
+			sp.advance(m.Length, run)
+		}
+		m, run = sp.findMatch(o)
+	}
+	return nil
+}
+
+// optimalBlock is how many input positions parseOptimal plans at once
+const optimalBlock = 1 << 14
+
+// parseOptimal records the longest match at every position of a block of
+// input, then picks the cheapest mix of literals (9 bits) and possibly
+// shortened matches (17 bits) for the block by dynamic programming. Tokens
+// starting in the last f positions of a block are planned again with the
+// next block, so matches are not cut short at block boundaries.
+func (sp *encodeState) parseOptimal(tw *tokenWriter, o *Options) error {
+	matches := make([]Match, 0, optimalBlock)
+	literals := make([]byte, 0, optimalBlock)
+	cost := make([]int, optimalBlock+1)
+	length := make([]int, optimalBlock)
+	min := threshold + 1
+	if o.MinMatch > min {
+		min = o.MinMatch
+	}
+
+	for sp.dataLen > 0 || len(matches) > 0 {
+		for len(matches) < optimalBlock && sp.dataLen > 0 {
+			m, _ := sp.findMatch(o)
+			matches = append(matches, m)
+			literals = append(literals, sp.textBuf[sp.r])
+			sp.advance(1, false)
+		}
+
+		end := len(matches)
+		cost[end] = 0
+		for i := end - 1; i >= 0; i-- {
+			cost[i] = cost[i+1] + 9
+			length[i] = 1
+			max := matches[i].Length
+			if i+max > end {
+				max = end - i
+			}
+			for k := min; k <= max; k++ {
+				if o.Alignment > 1 && zeroMatch(matches[i].Position, k) {
+					continue
+				}
+				// prefer the longer match on a tie, as the greedy parse would
+				if c := cost[i+k] + 17; c <= cost[i] {
+					cost[i] = c
+					length[i] = k
+				}
+			}
+		}
+
+		stop := end
+		if sp.dataLen > 0 {
+			stop = end - f
+		}
+		i := 0
+		for ; i < stop; i += length[i] {
+			var err error
+			if length[i] == 1 {
+				err = tw.literal(literals[i])
+			} else {
+				err = tw.match(Match{Position: matches[i].Position, Length: length[i]})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		matches = append(matches[:0], matches[i:end]...)
+		literals = append(literals[:0], literals[i:end]...)
+	}
+	return nil
+}
+
+// zeroMatch reports whether a match is packed as two zero bytes, which a
+// decoder given WithOutputAlignment cannot tell apart from the padding, so
+// the encoder sends literals instead when the option is set.
+func zeroMatch(position, length int) bool {
+	return position == 0 && length == threshold+1
+}
+
+// send sends m, or a literal if m is not long enough, setting m.Length to the
+// number of input bytes consumed.
+func (sp *encodeState) send(tw *tokenWriter, m *Match) error {
+	if m.Length <= threshold {
+		// Not long enough match. Send one byte.
+		m.Length = 1
+		return tw.literal(sp.textBuf[sp.r])
+	}
+	return tw.match(*m)
+}
@@ -0,0 +1,440 @@
+package lzss
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCompressRepeatedByte(t *testing.T) {
+	src := bytes.Repeat([]byte{0x41}, 1000)
+	dst, events := CompressTrace(src)
+
+	// one literal, then 55 distance 1 matches of f bytes and one of the
+	// 9 left, which may come from anywhere in the run: 57 tokens in 8 flag
+	// bytes, 1+8+56*2 = 121 bytes
+	if len(dst) > 121 {
+		t.Errorf("compressed to %d bytes, want at most 121", len(dst))
+	}
+	if len(events) == 0 || events[0].IsMatch() {
+		t.Fatalf("first token is not a literal: %+v", events)
+	}
+	for i, ev := range events[1 : len(events)-1] {
+		if !ev.IsMatch() || ev.Distance() != 1 || ev.Match.Length != f {
+			t.Fatalf("token %d is %+v, want a distance 1 match of length %d", i+1, ev, f)
+		}
+	}
+	if ev := events[len(events)-1]; !ev.IsMatch() || ev.Match.Length != (len(src)-1)%f {
+		t.Errorf("last token is %+v, want a match of the remaining %d bytes", ev, (len(src)-1)%f)
+	}
+	if got := Decompress(dst); !bytes.Equal(got, src) {
+		t.Errorf("round trip returned %d bytes, want %d", len(got), len(src))
+	}
+}
+
+// checkTrace checks that events cover src token by token and that dst holds
+// exactly those tokens behind ceil(tokens/8) flag bytes, so the final partial
+// group was flushed in full and nothing else.
+func checkTrace(t *testing.T, src, dst []byte, events []Event) {
+	t.Helper()
+	size, offset := (len(events)+7)/8, 0
+	for _, ev := range events {
+		if ev.Offset != offset {
+			t.Fatalf("token at offset %d, want %d", ev.Offset, offset)
+		}
+		if ev.IsMatch() {
+			size += 2
+			offset += ev.Match.Length
+		} else {
+			size++
+			offset++
+		}
+	}
+	if offset != len(src) {
+		t.Fatalf("tokens cover %d bytes, want %d", offset, len(src))
+	}
+	if len(dst) != size {
+		t.Fatalf("compressed to %d bytes, want %d for %d tokens", len(dst), size, len(events))
+	}
+	if got := Decompress(dst); !bytes.Equal(got, src) {
+		t.Fatalf("round trip of %d bytes returned %d", len(src), len(got))
+	}
+}
+
+func TestCompressTail(t *testing.T) {
+	head := []byte("0123456789")
+	// The last 10 bytes repeat head. Past the end of the input the ring
+	// holds zeros, so the bytes after head decide how far the finder's
+	// match runs past EOF before it is cut back to the data left.
+	tests := []struct {
+		name  string
+		after []byte
+	}{
+		{"ends at EOF", []byte("x")},
+		{"one past EOF", []byte{0, 'x'}},
+		{"well past EOF", make([]byte, f)},
+	}
+	for _, tt := range tests {
+		for pad := 0; pad < 8; pad++ {
+			// pad shifts the last match through every slot of its group
+			src := append(bytes.Repeat([]byte("-"), pad), head...)
+			src = append(append(src, tt.after...), head...)
+			dst, events := CompressTrace(src)
+			checkTrace(t, src, dst, events)
+			last := events[len(events)-1]
+			if !last.IsMatch() || last.Match.Length != len(head) {
+				t.Errorf("%s, pad %d: last token is %+v, want a match of %d bytes", tt.name, pad, last, len(head))
+			}
+		}
+	}
+}
+
+func TestCompressShortLengths(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for l := 0; l < 100; l++ {
+		src := make([]byte, l)
+		for i := range src {
+			src[i] = byte(rnd.Intn(3))
+		}
+		dst, events := CompressTrace(src)
+		checkTrace(t, src, dst, events)
+	}
+}
+
+func TestCompressAllByteValues(t *testing.T) {
+	// every byte value ascending, as literals, then descending in runs of
+	// up to 19 bytes and shuffled, so high-bit bytes land in every slot of
+	// literals, match nibbles and flag bytes
+	var src []byte
+	for i := 0; i < 256; i++ {
+		src = append(src, byte(i))
+	}
+	for i := 255; i >= 0; i-- {
+		src = append(src, bytes.Repeat([]byte{byte(i)}, i%20)...)
+	}
+	for _, i := range rand.New(rand.NewSource(3)).Perm(256) {
+		src = append(src, byte(i), byte(i), byte(255-i))
+	}
+
+	for _, p := range []Packing{PackPositionHigh, PackLengthHigh} {
+		dst, events := CompressTrace(src, WithPacking(p))
+		for i, ev := range events[:256] {
+			if ev.IsMatch() || ev.Literal != byte(i) {
+				t.Fatalf("packing %d: token %d is %+v, want the literal %#02x", p, i, ev, i)
+			}
+		}
+		if got := Decompress(dst, WithPacking(p)); !bytes.Equal(got, src) {
+			t.Errorf("packing %d: round trip returned %d bytes, want %d", p, len(got), len(src))
+		}
+	}
+}
+
+func TestCompressIgnoresCapacity(t *testing.T) {
+	for _, src := range corpus() {
+		// the same bytes with spare capacity full of other data after them
+		big := make([]byte, len(src), 2*len(src)+100)
+		copy(big, src)
+		spare := big[len(src):cap(big)]
+		for i := range spare {
+			spare[i] = 0xAA
+		}
+		exact := append([]byte(nil), src...)[:len(src):len(src)]
+		if a, b := Compress(big), Compress(exact); !bytes.Equal(a, b) {
+			t.Errorf("%d bytes compress to %d with spare capacity, %d without", len(src), len(a), len(b))
+		}
+	}
+}
+
+func TestCompressGroupBoundaries(t *testing.T) {
+	// k distinct bytes are k literals: full groups get flag 0xFF and the
+	// final partial group one bit per token it holds
+	for _, k := range []int{8, 15, 16} {
+		src := make([]byte, k)
+		for i := range src {
+			src[i] = byte(i * 37)
+		}
+		dst, events := CompressTrace(src)
+		if len(events) != k {
+			t.Fatalf("%d bytes compressed to %d tokens, want %d literals", k, len(events), k)
+		}
+		checkTrace(t, src, dst, events)
+
+		for g := 0; g*8 < k; g++ {
+			left := k - g*8
+			if left > 8 {
+				left = 8
+			}
+			flag := dst[g*9]
+			if want := byte(1<<uint(left) - 1); flag != want {
+				t.Errorf("%d tokens: group %d flag is %#02x, want %#02x", k, g, flag, want)
+			}
+			if got, want := dst[g*9+1:g*9+1+left], src[g*8:g*8+left]; !bytes.Equal(got, want) {
+				t.Errorf("%d tokens: group %d holds % x, want % x", k, g, got, want)
+			}
+		}
+	}
+}
+
+// sparseImage is a mostly zero 4 MB buffer with a 4 KB random island every
+// 256 KB, like a firmware image
+func sparseImage() []byte {
+	rnd := rand.New(rand.NewSource(1))
+	src := make([]byte, 4<<20)
+	for off := 0; off < len(src); off += 256 << 10 {
+		rnd.Read(src[off : off+4<<10])
+	}
+	return src
+}
+
+func BenchmarkCompressSparse(b *testing.B) {
+	src := sparseImage()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Compress(src)
+	}
+}
+
+// messages returns 1000 small JSON-like messages for the batch benchmarks
+func messages() [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	msgs := make([][]byte, 1000)
+	for i := range msgs {
+		msgs[i] = []byte(fmt.Sprintf(`{"id":%d,"user":"u%d","event":"click","ok":true}`, i, rnd.Intn(100)))
+	}
+	return msgs
+}
+
+func BenchmarkCompressBatch(b *testing.B) {
+	msgs := messages()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CompressBatch(msgs)
+	}
+}
+
+func BenchmarkCompressLoop(b *testing.B) {
+	msgs := messages()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, msg := range msgs {
+			Compress(msg)
+		}
+	}
+}
+
+func BenchmarkCompressSingleByte(b *testing.B) {
+	src := bytes.Repeat([]byte{0x41}, 16<<20)
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		Compress(src)
+	}
+}
+
+func TestParsingStrategies(t *testing.T) {
+	names := []string{Greedy: "greedy", Lazy: "lazy", Optimal: "optimal"}
+	c := corpus()
+	// the repeated phrase and the random text
+	text := [][]byte{c[4], c[6]}
+
+	for _, src := range c {
+		for p := Greedy; p <= Optimal; p++ {
+			dst := Compress(src, WithParsing(p))
+			if got := Decompress(dst); !bytes.Equal(got, src) {
+				t.Fatalf("%s: %d bytes did not round trip", names[p], len(src))
+			}
+		}
+	}
+
+	for _, src := range text {
+		var sizes [Optimal + 1]int
+		for p := Greedy; p <= Optimal; p++ {
+			start := time.Now()
+			sizes[p] = len(Compress(src, WithParsing(p)))
+			t.Logf("%s: %d bytes to %d (%.1f%%) in %v", names[p], len(src), sizes[p],
+				100*float64(sizes[p])/float64(len(src)), time.Since(start))
+		}
+		if sizes[Lazy] > sizes[Greedy] || sizes[Optimal] > sizes[Greedy] {
+			t.Errorf("%d bytes: lazy %d and optimal %d bytes, want no more than greedy %d",
+				len(src), sizes[Lazy], sizes[Optimal], sizes[Greedy])
+		}
+	}
+}
+
+// BenchmarkCompareFlate compresses text, random binary and a sparse image with
+// this package and with compress/flate, reporting the ratio next to the
+// throughput.
+func BenchmarkCompareFlate(b *testing.B) {
+	c := corpus()
+	inputs := []struct {
+		name string
+		src  []byte
+	}{
+		{"text", bytes.Repeat(c[6], 4)},
+		{"binary", c[5]},
+		{"sparse", sparseImage()},
+	}
+	for _, in := range inputs {
+		src := in.src
+		b.Run(in.name+"/lzss", func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			var size int
+			for i := 0; i < b.N; i++ {
+				size = len(Compress(src))
+			}
+			b.ReportMetric(float64(size)/float64(len(src)), "ratio")
+		})
+		b.Run(in.name+"/flate", func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			var buf bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+				if err != nil {
+					b.Fatal(err)
+				}
+				fw.Write(src)
+				fw.Close()
+			}
+			b.ReportMetric(float64(buf.Len())/float64(len(src)), "ratio")
+		})
+	}
+}
+
+func TestCompressTieBreaking(t *testing.T) {
+	// "abc" and longer strings recur at several distances; the tree picks
+	// the same one every time, not necessarily the nearest
+	src := []byte("abcXabcYabcZabcXabcYabcabcXabc")
+	want := []struct{ offset, distance, length int }{
+		{4, 4, 3},
+		{8, 8, 3},
+		{12, 12, 11},
+		{23, 23, 7},
+	}
+
+	for run := 0; run < 3; run++ {
+		dst, events := CompressTrace(src)
+		checkTrace(t, src, dst, events)
+		var i int
+		for _, e := range events {
+			if !e.IsMatch() {
+				continue
+			}
+			if i < len(want) && (e.Offset != want[i].offset || e.Distance() != want[i].distance || e.Match.Length != want[i].length) {
+				t.Errorf("run %d: match %d at %d copies %d bytes from distance %d, want %+v", run, i, e.Offset, e.Match.Length, e.Distance(), want[i])
+			}
+			i++
+		}
+		if i != len(want) {
+			t.Errorf("run %d: %d matches, want %d", run, i, len(want))
+		}
+	}
+
+	// pooled encoder state left over from other inputs changes nothing
+	inputs := append(corpus(), src)
+	batch := CompressBatch(inputs)
+	for i, src := range inputs {
+		if want := Compress(src); !bytes.Equal(batch[i], want) {
+			t.Errorf("CompressBatch of %d bytes differs from Compress", len(src))
+		}
+	}
+}
+
+func TestCompressStreamGroupFlush(t *testing.T) {
+	src := make([]byte, 100)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		_, err := CompressStream(outW, inR, WithGroupFlush(true))
+		outW.CloseWithError(err)
+	}()
+
+	// 8 literals, and the f bytes of lookahead the last of them needs
+	if _, err := inW.Write(src[:8+f]); err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, 9)
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(outR, first)
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first group was not written before the input ended")
+	}
+
+	go func() {
+		inW.Write(src[8+f:])
+		inW.Close()
+	}()
+	rest, err := ioutil.ReadAll(outR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := append(first, rest...), Compress(src); !bytes.Equal(got, want) {
+		t.Errorf("streamed % x, want % x", got, want)
+	}
+}
+
+// minThroughput is the compression speed, in MB/s, TestCompressThroughput
+// fails below. The corpus compresses at around 10 MB/s on a typical machine
+// and at under 1 MB/s with -race, so only a drastic regression trips it.
+const minThroughput = 0.5
+
+func TestCompressThroughput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmarks compression")
+	}
+	src := bytes.Join(corpus(), nil)
+	r := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			Compress(src)
+		}
+	})
+	if mbs := float64(r.Bytes) * float64(r.N) / r.T.Seconds() / 1e6; mbs < minThroughput {
+		t.Errorf("compressed at %.2f MB/s, want at least %.2f", mbs, minThroughput)
+	}
+}
+
+func TestCompressStreamMemory(t *testing.T) {
+	big := sparseImage()
+	// allocated bytes and allocations of one CompressStream call
+	measure := func(src []byte, opts []Option) (uint64, float64) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		CompressStream(ioutil.Discard, bytes.NewReader(src), opts...)
+		runtime.ReadMemStats(&after)
+		allocs := testing.AllocsPerRun(2, func() {
+			CompressStream(ioutil.Discard, bytes.NewReader(src), opts...)
+		})
+		return after.TotalAlloc - before.TotalAlloc, allocs
+	}
+
+	for _, opts := range [][]Option{nil, {WithParsing(Lazy)}, {WithParsing(Optimal)}, {WithLiteralRuns(true)}} {
+		small, smallAllocs := measure(big[:64<<10], opts)
+		large, largeAllocs := measure(big, opts)
+		// the runtime may allocate a few bytes of its own meanwhile
+		if large > small+1024 || largeAllocs != smallAllocs {
+			t.Errorf("%d bytes in %v allocations for 64 KiB of input, %d in %v for 4 MiB", small, smallAllocs, large, largeAllocs)
+		}
+		// EncoderMemoryBytes leaves out allocator rounding and small structs
+		if want := EncoderMemoryBytes(opts...); large > uint64(want)*3/2 {
+			t.Errorf("allocated %d bytes, EncoderMemoryBytes says %d", large, want)
+		}
+	}
+}
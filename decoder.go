@@ -0,0 +1,152 @@
+package lzss
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// decoder holds the state of an in-progress decode
+type decoder struct {
+	TokenReader
+
+	// ring buffer of size n, with extra f-1 bytes to aid string comparison
+	textBuf []byte
+	r       int
+
+	// tok holds the bytes produced by the last token
+	tok [f + 1]byte
+	// pending is the part of tok not yet returned by ReadByte
+	pending []byte
+}
+
+// maxExpansion returns the most bytes srcLen bytes of lzss data can decode to:
+// every flag byte followed by eight matches of f bytes each.
+func maxExpansion(srcLen int) int {
+	return (srcLen/17 + 1) * 8 * f
+}
+
+// scratchPool holds ring buffers for decoders that do not outlive the call
+// that made them, so decoding many small blobs does not allocate one each.
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		return new([ScratchSize]byte)
+	},
+}
+
+func newDecoder(src []byte) *decoder {
+	return newDecoderBytes(src, nil)
+}
+
+// newDecoderBytes is newDecoderBuf reading from src, which lets it recognize
+// trailing zero padding, see WithZeroPadding.
+func newDecoderBytes(src, textBuf []byte) *decoder {
+	d := newDecoderBuf(bytes.NewReader(src), textBuf)
+	d.src = src
+	return d
+}
+
+// newDecoderBuf returns a decoder reading from r that uses textBuf as its ring
+// buffer if it is big enough, resetting it first so no state leaks between calls.
+func newDecoderBuf(r io.ByteReader, textBuf []byte) *decoder {
+	if len(textBuf) < ScratchSize {
+		textBuf = make([]byte, ScratchSize)
+	} else {
+		textBuf = textBuf[:ScratchSize]
+	}
+	// The encoder starts with the ring buffer space-filled up to r, so a
+	// stream may legally open with a match into that region.
+	for i := range textBuf {
+		if i < n-f {
+			textBuf[i] = ' '
+		} else {
+			textBuf[i] = 0
+		}
+	}
+	return &decoder{
+		TokenReader: TokenReader{r: r, padAt: -1},
+		textBuf:     textBuf,
+		r:           n - f,
+	}
+}
+
+// setOptions applies the options to the token parsing and seeds the ring
+// buffer with any WithRingSnapshot.
+func (d *decoder) setOptions(o Options) {
+	d.TokenReader.setOptions(o)
+	copy(d.textBuf[n-f-len(o.RingSnapshot):], o.RingSnapshot)
+}
+
+// next decodes the next token and returns the bytes it produced. The returned
+// slice is only valid until the following call. It returns io.EOF when src is
+// exhausted on a token boundary and a *DecodeError when it is malformed.
+func (d *decoder) next() ([]byte, error) {
+	ev, err := d.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ev.IsMatch() {
+		d.tok[0] = ev.Literal
+		d.textBuf[d.r] = ev.Literal
+		d.r++
+		d.r &= (n - 1)
+		return d.tok[:1], nil
+	}
+
+	for k := 0; k < ev.Match.Length; k++ {
+		c := d.textBuf[(ev.Match.Position+k)&(n-1)]
+		d.tok[k] = c
+		d.textBuf[d.r] = c
+		d.r++
+		d.r &= (n - 1)
+	}
+	return d.tok[:ev.Match.Length], nil
+}
+
+// ReadByte returns the next decoded byte, so a decoder can feed the encoder
+// directly without holding the decoded data in memory.
+func (d *decoder) ReadByte() (byte, error) {
+	for len(d.pending) == 0 {
+		tok, err := d.next()
+		if err != nil {
+			return 0, err
+		}
+		d.pending = tok
+	}
+	c := d.pending[0]
+	d.pending = d.pending[1:]
+	return c, nil
+}
+
+// Decoder decodes one buffer of lzss data on demand, remembering the options
+// it was written with.
+type Decoder struct {
+	src []byte
+	o   Options
+}
+
+// NewDecoder returns a Decoder for src, which must be decoded with opts
+func NewDecoder(src []byte, opts ...Option) *Decoder {
+	return &Decoder{src: src, o: mustOptions(opts)}
+}
+
+// Decode decompresses the whole buffer. Each call decodes it again, so a
+// Decoder can stand in for the decompressed data in a cache.
+func (d *Decoder) Decode() ([]byte, error) {
+	return decompress(d.src, d.o)
+}
+
+// Reader returns a reader that decompresses the buffer as it is read
+func (d *Decoder) Reader() io.Reader {
+	return newReader(bytes.NewReader(d.src), 0, d.o)
+}
+
+// CompressWithDecoder compresses src like Compress and also returns a Decoder
+// for the result, set up with the same options. The Decoder reads the
+// returned bytes, so they must not be modified.
+func CompressWithDecoder(src []byte, opts ...Option) ([]byte, *Decoder) {
+	o := mustOptions(opts)
+	dat := compressBytes(src, o, nil, nil)
+	return dat, &Decoder{src: dat, o: o}
+}
@@ -0,0 +1,60 @@
+package lzss
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecodeSpaceFilledRing(t *testing.T) {
+	// The ring starts out as n-f spaces, so a stream may open with a match
+	// into them before any literal is sent.
+	tests := []struct {
+		name string
+		src  []byte
+		want string
+	}{
+		// flag 0x00: a match at position 0 of length 3
+		{"match at 0", []byte{0x00, 0x00, 0x00}, "   "},
+		// flag 0x02: a match at position 0 of length f, then a literal
+		{"longest match then literal", []byte{0x02, 0x00, 0x0F, 'x'}, "                  x"},
+		// flag 0x00: a match of length 3 ending just before the ring start
+		{"match before r", []byte{0x00, (n - f - 3) & 0xFF, (n - f - 3) >> 4 & 0xF0}, "   "},
+	}
+	for _, tt := range tests {
+		if got := Decompress(tt.src); string(got) != tt.want {
+			t.Errorf("%s: Decompress = %q, want %q", tt.name, got, tt.want)
+		}
+		got, err := ioutil.ReadAll(NewReader(bytes.NewReader(tt.src)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: NewReader = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeInvertedFlags(t *testing.T) {
+	// flag 0xFE reads as 0x01 inverted: a literal 'a', then a match of 3
+	// bytes from ring position n-f, where the 'a' was written
+	src := []byte{0xFE, 'a', (n - f) & 0xFF, (n - f) >> 4 & 0xF0}
+	if got := Decompress(src, WithInvertedFlags(true)); string(got) != "aaaa" {
+		t.Errorf("inverted Decompress = %q, want %q", got, "aaaa")
+	}
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(src), WithInvertedFlags(true)))
+	if err != nil || string(got) != "aaaa" {
+		t.Errorf("inverted NewReader = %q, %v, want %q", got, err, "aaaa")
+	}
+	// read with the default polarity the same bytes are a match and literals
+	if got := Decompress(src); bytes.Equal(got, []byte("aaaa")) {
+		t.Errorf("default Decompress also returned %q", got)
+	}
+
+	for _, c := range corpus() {
+		dst := Compress(c, WithInvertedFlags(true))
+		if got := Decompress(dst, WithInvertedFlags(true)); !bytes.Equal(got, c) {
+			t.Errorf("inverted round trip of %d bytes returned %d", len(c), len(got))
+		}
+	}
+}
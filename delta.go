@@ -0,0 +1,59 @@
+package lzss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+)
+
+// deltaMagic starts every delta and carries its version in the last byte
+var deltaMagic = [4]byte{'L', 'Z', 'D', 1}
+
+// deltaHeaderSize is the magic plus the big-endian Adler-32 of the base
+const deltaHeaderSize = 4 + 4
+
+// CompressDelta compresses target with the end of base preloaded into the
+// ring buffer, see WithRingSnapshot, so bytes target shares with base cost
+// matches rather than literals. ApplyDelta rebuilds target from base and the
+// delta:
+//
+//	"LZD" version(1) [base Adler-32 uint32][lzss stream]
+//
+// The window is 4 KiB, so matches can only reach the last n-f (4078) bytes of
+// base; this suits data appended to a base or small files, not patching large
+// images in the middle.
+func CompressDelta(base, target []byte) ([]byte, error) {
+	dst := bytes.Buffer{}
+	dst.Write(deltaMagic[:])
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], adler32.Checksum(base))
+	dst.Write(sum[:])
+	CompressToBuffer(&dst, target, WithRingSnapshot(deltaSnapshot(base)))
+	return dst.Bytes(), nil
+}
+
+// ApplyDelta rebuilds the target of CompressDelta from base and delta. It
+// fails if base is not the one the delta was made against.
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	if len(delta) < deltaHeaderSize || !bytes.Equal(delta[:3], deltaMagic[:3]) {
+		return nil, errors.New("missing delta magic")
+	}
+	if v := delta[3]; v != deltaMagic[3] {
+		return nil, fmt.Errorf("unsupported delta version %d", v)
+	}
+	if sum := adler32.Checksum(base); sum != binary.BigEndian.Uint32(delta[4:]) {
+		return nil, fmt.Errorf("base checksum %#08x does not match the delta's %#08x", sum, binary.BigEndian.Uint32(delta[4:]))
+	}
+	return decompress(delta[deltaHeaderSize:], Options{RingSnapshot: deltaSnapshot(base)})
+}
+
+// deltaSnapshot returns the part of base that fits in the ring before the
+// start of the output
+func deltaSnapshot(base []byte) []byte {
+	if len(base) > n-f {
+		return base[len(base)-(n-f):]
+	}
+	return base
+}
@@ -0,0 +1,160 @@
+package lzss
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Format is a kind of data told apart by DetectFormat
+type Format int
+
+const (
+	// FormatUnknown is data that does not look like lzss, most likely raw
+	// uncompressed bytes
+	FormatUnknown Format = iota
+	// FormatLZSS is a raw lzss stream
+	FormatLZSS
+	// FormatComplzss is a complzss file: a Header followed by lzss data
+	FormatComplzss
+)
+
+// detectLimit is how many tokens LooksCompressed checks before giving up
+const detectLimit = 1 << 16
+
+// DetectFormat guesses what src holds. A complzss file is recognized by its
+// header magic; raw lzss streams are recognized by LooksCompressed.
+func DetectFormat(src []byte, opts ...Option) Format {
+	switch {
+	case hasHeaderMagic(src):
+		return FormatComplzss
+	case LooksCompressed(src, opts...):
+		return FormatLZSS
+	default:
+		return FormatUnknown
+	}
+}
+
+// LooksCompressed reports whether src plausibly is lzss data. Decompress has no
+// way to tell: any bytes decode to something, so raw data passed to it comes
+// back as garbage without an error. LooksCompressed checks that src ends on a
+// token boundary and that no match reaches back past the start of the output
+// (beyond the f bytes of ring fill an Okumura encoder may match against),
+// which raw data breaks almost immediately. Match distances are only checked
+// in the first 64K tokens, but the whole stream is walked to find its end.
+// Empty input is not considered compressed.
+func LooksCompressed(src []byte, opts ...Option) bool {
+	if len(src) == 0 {
+		return false
+	}
+	if hasHeaderMagic(src) {
+		return true
+	}
+
+	t := NewTokenReader(src, opts...)
+	for i := 0; ; i++ {
+		ev, err := t.Next()
+		if err == io.EOF {
+			return true
+		} else if err != nil {
+			return false
+		}
+		if !ev.IsMatch() || i >= detectLimit {
+			continue
+		}
+		if !ev.canonical(0) {
+			return false
+		}
+	}
+}
+
+// gzipMagic starts every gzip member
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// DecompressAuto is a best-effort decoder for payloads of uncertain wrapping.
+// If src is gzip compressed, as lzss data sometimes is for transport, it is
+// gunzipped first. The result is then decoded with DecompressFile if it starts
+// with a complzss Header and as a raw lzss stream otherwise. Raw streams carry
+// no signature, so data that is not lzss at all decodes to garbage rather than
+// failing; check with DetectFormat when that matters.
+func DecompressAuto(src []byte) ([]byte, error) {
+	if bytes.HasPrefix(src, gzipMagic) {
+		// an lzss stream may start with the magic too, but rarely with a
+		// whole valid gzip header
+		if zr, err := gzip.NewReader(bytes.NewReader(src)); err == nil {
+			if src, err = ioutil.ReadAll(zr); err != nil {
+				return nil, fmt.Errorf("failed to gunzip: %w", err)
+			}
+		}
+	}
+	if hasHeaderMagic(src) {
+		return DecompressFile(src)
+	}
+	return DecompressWith(nil, src)
+}
+
+// Variant is a set of token format options an lzss stream may use
+type Variant struct {
+	Name        string
+	Packing     Packing
+	InvertFlags bool
+}
+
+// Options returns the options that decode the variant
+func (v Variant) Options() []Option {
+	return []Option{WithPacking(v.Packing), WithInvertedFlags(v.InvertFlags)}
+}
+
+// knownVariants are the token formats IdentifyVariant tries, the standard one first
+var knownVariants = []Variant{
+	{Name: "okumura", Packing: PackPositionHigh},
+	{Name: "length-high", Packing: PackLengthHigh},
+	{Name: "inverted-flags", Packing: PackPositionHigh, InvertFlags: true},
+	{Name: "length-high-inverted-flags", Packing: PackLengthHigh, InvertFlags: true},
+}
+
+// VariantGuess is the result of IdentifyVariant
+type VariantGuess struct {
+	// Complzss is set when src starts with a complzss Header, in which case
+	// only the data after it was checked
+	Complzss bool
+	// Candidates are the variants that decode src cleanly, standard first
+	Candidates []Variant
+}
+
+// ErrUnknownVariant is returned by IdentifyVariant when no known variant
+// decodes the data cleanly.
+var ErrUnknownVariant = errors.New("no known lzss variant decodes the data")
+
+// IdentifyVariant tries decoding src with every known token format and
+// reports those under which it looks like a complete lzss stream, as judged
+// by LooksCompressed. Apple's complzss and Okumura's reference encoder share
+// the "okumura" format. This is a heuristic for reverse engineering unknown
+// blobs: more than one variant may fit, especially for short inputs.
+func IdentifyVariant(src []byte) (VariantGuess, error) {
+	var guess VariantGuess
+	if hasHeaderMagic(src) {
+		hdr, err := ParseHeaderAt(src, 0)
+		if err != nil {
+			return guess, err
+		}
+		guess.Complzss = true
+		src = src[headerSize:]
+		if int(hdr.CompressedSize) <= len(src) {
+			src = src[:hdr.CompressedSize]
+		}
+	}
+
+	for _, v := range knownVariants {
+		if LooksCompressed(src, v.Options()...) {
+			guess.Candidates = append(guess.Candidates, v)
+		}
+	}
+	if len(guess.Candidates) == 0 {
+		return guess, ErrUnknownVariant
+	}
+	return guess, nil
+}
@@ -0,0 +1,31 @@
+package lzss
+
+import "testing"
+
+func TestLooksCompressed(t *testing.T) {
+	for _, src := range corpus() {
+		// a byte or two of raw data can be a valid stream too
+		if len(src) >= 16 {
+			if LooksCompressed(src) {
+				t.Errorf("LooksCompressed accepted %d raw bytes", len(src))
+			}
+			if got := DetectFormat(src); got != FormatUnknown {
+				t.Errorf("DetectFormat of %d raw bytes = %v, want FormatUnknown", len(src), got)
+			}
+		}
+		if len(src) == 0 {
+			continue
+		}
+
+		dst := Compress(src)
+		if !LooksCompressed(dst) {
+			t.Errorf("LooksCompressed rejected %d bytes compressed from %d", len(dst), len(src))
+		}
+		if got := DetectFormat(dst); got != FormatLZSS {
+			t.Errorf("DetectFormat of %d bytes compressed from %d = %v, want FormatLZSS", len(dst), len(src), got)
+		}
+		if got := DetectFormat(CompressFile(src)); got != FormatComplzss {
+			t.Errorf("DetectFormat of a complzss file of %d bytes = %v, want FormatComplzss", len(src), got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lzss
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Encoding is a text encoding for compressed data
+type Encoding int
+
+const (
+	// Base64 is standard base64 encoding as defined in RFC 4648
+	Base64 Encoding = iota
+	// Hex is lowercase hexadecimal encoding
+	Hex
+)
+
+// CompressToString compresses src and returns it as text in the given encoding.
+// It panics on an unknown encoding, which DecompressFromString could not read.
+func CompressToString(src []byte, enc Encoding) string {
+	dat := Compress(src)
+	switch enc {
+	case Base64:
+		return base64.StdEncoding.EncodeToString(dat)
+	case Hex:
+		return hex.EncodeToString(dat)
+	default:
+		panic(fmt.Sprintf("lzss: unknown encoding %d", enc))
+	}
+}
+
+// DecompressFromString decodes s from the given encoding and decompresses it
+func DecompressFromString(s string, enc Encoding) ([]byte, error) {
+	var dat []byte
+	var err error
+
+	switch enc {
+	case Base64:
+		dat, err = base64.StdEncoding.DecodeString(s)
+	case Hex:
+		dat, err = hex.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("unknown encoding %d", enc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode string: %w", err)
+	}
+
+	return Decompress(dat), nil
+}
+
+// CompressToColumns is CompressToString with the text broken into lines of
+// width characters, each ending in a newline, so compressed blobs kept in
+// version control give line-based diffs. A width of 0 or less puts all the
+// text on one line. DecompressFromColumns reverses it.
+func CompressToColumns(src []byte, enc Encoding, width int) string {
+	s := CompressToString(src, enc)
+	if width <= 0 {
+		return s + "\n"
+	}
+	var b strings.Builder
+	b.Grow(len(s) + len(s)/width + 1)
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteByte('\n')
+		s = s[width:]
+	}
+	b.WriteString(s)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// DecompressFromColumns removes the line breaks CompressToColumns added, CRLF
+// ones included, and decompresses the rest like DecompressFromString. Neither
+// encoding uses newlines itself, so nothing else is lost.
+func DecompressFromColumns(s string, enc Encoding) ([]byte, error) {
+	s = strings.NewReplacer("\r", "", "\n", "").Replace(s)
+	return DecompressFromString(s, enc)
+}
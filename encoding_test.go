@@ -0,0 +1,28 @@
+package lzss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStringRoundTrip(t *testing.T) {
+	src := bytes.Repeat([]byte("hello world "), 50)
+	for _, enc := range []Encoding{Base64, Hex} {
+		got, err := DecompressFromString(CompressToString(src, enc), enc)
+		if err != nil || !bytes.Equal(got, src) {
+			t.Errorf("encoding %d: round trip failed: %v", enc, err)
+		}
+	}
+}
+
+func TestStringUnknownEncoding(t *testing.T) {
+	if _, err := DecompressFromString("", Encoding(99)); err == nil {
+		t.Error("DecompressFromString accepted an unknown encoding")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("CompressToString accepted an unknown encoding")
+		}
+	}()
+	CompressToString([]byte("x"), Encoding(99))
+}
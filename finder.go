@@ -0,0 +1,265 @@
+package lzss
+
+// nilIdx is the index for root of binary search trees
+const nilIdx = n
+
+// MatchFinder finds back-references for the encoder. The encoder owns the ring
+// buffer and tells the finder which positions hold strings it may match.
+type MatchFinder interface {
+	// Reset prepares the finder for a new stream. window is the encoder's
+	// ring buffer: n bytes followed by a copy of the first f-1 bytes, so the
+	// string at any position can be compared without wrapping.
+	Reset(window []byte)
+	// Insert registers the string of f bytes at ring position pos and returns
+	// the longest match for it among the registered strings. Positions are
+	// inserted in ring order, though the encoder may skip some of them.
+	// The choice between equally long matches must depend only on the
+	// strings inserted, so that the same input always compresses the same.
+	Insert(pos int) Match
+	// Remove unregisters ring position pos before it is overwritten
+	Remove(pos int)
+}
+
+// treeFinder is the default MatchFinder, using a binary search tree for the
+// strings starting with each byte value.
+type treeFinder struct {
+	// left & right children & parent. These constitute binary search trees.
+	lchild, rchild, parent []int
+	textBuf                []byte
+	// matchPosition and matchLength of longest match.
+	// These are set by the insertNode() procedure.
+	matchPosition, matchLength int
+}
+
+// NewTreeFinder returns the default MatchFinder, which keeps the strings in the
+// window in binary search trees as in Okumura's original LZSS. Of equally long
+// matches it picks the first met on the search path from the root, except
+// that a full f byte match always goes to the newest position. The tree shape
+// depends only on the input, so the choice is deterministic, but it is not
+// always the nearest position.
+func NewTreeFinder() MatchFinder {
+	return &treeFinder{}
+}
+
+// Reset initializes the binary search trees
+func (t *treeFinder) Reset(window []byte) {
+	t.textBuf = window
+	if t.lchild == nil {
+		t.lchild = make([]int, n+1)
+		t.rchild = make([]int, n+257)
+		t.parent = make([]int, n+1)
+	}
+	// for i = 0 to n - 1, rchild[i] and lchild[i] will be the right and
+	// left children of node i. These nodes need not be initialized.
+	// Also, parent[i] is the parent of node i. These are initialized to
+	// nilIdx (= n), which stands for 'not used.'
+	// For i = 0 to 255, rchild[n + i + 1] is the root of the tree
+	// for strings that begin with character i. These are initialized
+	// to nilIdx. Note there are 256 trees.
+	for i := n + 1; i <= n+256; i++ {
+		t.rchild[i] = nilIdx
+	}
+	for i := 0; i < n; i++ {
+		t.parent[i] = nilIdx
+	}
+}
+
+// Insert inserts the string at pos into the trees
+func (t *treeFinder) Insert(pos int) Match {
+	t.insertNode(pos)
+	return Match{Position: t.matchPosition, Length: t.matchLength}
+}
+
+// Remove deletes pos from the trees
+func (t *treeFinder) Remove(pos int) {
+	t.deleteNode(pos)
+}
+
+// insertNode inserts string of length f, textBuf[r..r+f-1], into one of the
+// trees (textBuf[r]'th tree) and returns the longest-match position
+// and length via the global variables matchPosition and matchLength.
+// If matchLength = f, then removes the old node in favor of the new
+// one, because the old one will be deleted sooner.
+// Note r plays double role, as tree node and position in buffer.
+func (t *treeFinder) insertNode(r int) {
+	cmp := 1
+	key := t.textBuf[r:]
+	p := n + 1 + int(key[0])
+
+	t.rchild[r] = nilIdx
+	t.lchild[r] = nilIdx
+	t.matchLength = 0
+
+	for {
+		if cmp >= 0 {
+			if t.rchild[p] != nilIdx {
+				p = t.rchild[p]
+			} else {
+				t.rchild[p] = r
+				t.parent[r] = p
+				return
+			}
+		} else {
+			if t.lchild[p] != nilIdx {
+				p = t.lchild[p]
+			} else {
+				t.lchild[p] = r
+				t.parent[r] = p
+				return
+			}
+		}
+		i := 1
+		for ; i < f; i++ {
+			cmp = int(key[i]) - int(t.textBuf[p+i])
+			if cmp != 0 {
+				break
+			}
+		}
+		if i > t.matchLength {
+			t.matchPosition = p
+			t.matchLength = i
+			if i >= f {
+				break
+			}
+		}
+	}
+
+	t.parent[r] = t.parent[p]
+	t.lchild[r] = t.lchild[p]
+	t.rchild[r] = t.rchild[p]
+	t.parent[t.lchild[p]] = r
+	t.parent[t.rchild[p]] = r
+	if t.rchild[t.parent[p]] == p {
+		t.rchild[t.parent[p]] = r
+	} else {
+		t.lchild[t.parent[p]] = r
+	}
+	t.parent[p] = nilIdx // remove p
+}
+
+// deleteNode deletes node p from tree
+func (t *treeFinder) deleteNode(p int) {
+	var q int
+
+	if t.parent[p] == nilIdx {
+		return // not in tree
+	}
+
+	if t.rchild[p] == nilIdx {
+		q = t.lchild[p]
+	} else if t.lchild[p] == nilIdx {
+		q = t.rchild[p]
+	} else {
+		q = t.lchild[p]
+		if t.rchild[q] != nilIdx {
+			for {
+				q = t.rchild[q]
+				if t.rchild[q] == nilIdx {
+					break
+				}
+			}
+			t.rchild[t.parent[q]] = t.lchild[q]
+			t.parent[t.lchild[q]] = t.parent[q]
+			t.lchild[q] = t.lchild[p]
+			t.parent[t.lchild[p]] = q
+		}
+		t.rchild[q] = t.rchild[p]
+		t.parent[t.rchild[p]] = q
+	}
+	t.parent[q] = t.parent[p]
+	if t.rchild[t.parent[p]] == p {
+		t.rchild[t.parent[p]] = q
+	} else {
+		t.lchild[t.parent[p]] = q
+	}
+	t.parent[p] = nilIdx
+}
+
+const (
+	// hashBits is the size of the hash chain heads table
+	hashBits = 12
+	// defaultChainDepth is how many candidates a hash chain search tries by default
+	defaultChainDepth = 32
+)
+
+// hashChainFinder is a MatchFinder that chains together the positions whose
+// first three bytes hash alike, and searches the most recent ones first.
+type hashChainFinder struct {
+	window []byte
+	depth  int
+
+	// head holds the absolute offset of the newest position for each hash
+	// plus one, so zero means empty. prev links each position to the next
+	// older one in its chain the same way.
+	head [1 << hashBits]int
+	prev [n]int
+
+	// absolute offset of the last inserted position, plus one
+	last    int
+	lastPos int
+}
+
+// NewHashChainFinder returns a MatchFinder using hash chains, which trades a
+// little ratio for speed on data where the binary search trees grow deep.
+// depth caps how many earlier positions are compared per search; values of
+// zero or less use a default. Of equally long matches it always picks the
+// nearest position.
+func NewHashChainFinder(depth int) MatchFinder {
+	if depth <= 0 {
+		depth = defaultChainDepth
+	}
+	return &hashChainFinder{depth: depth}
+}
+
+// Reset empties the hash chains
+func (h *hashChainFinder) Reset(window []byte) {
+	h.window = window
+	h.head = [1 << hashBits]int{}
+	h.last = 0
+}
+
+func (h *hashChainFinder) hash(pos int) int {
+	w := h.window[pos:]
+	return (int(w[0])<<8 ^ int(w[1])<<4 ^ int(w[2])) & (1<<hashBits - 1)
+}
+
+// Insert searches the chain for pos, newest first, and then adds pos to it
+func (h *hashChainFinder) Insert(pos int) Match {
+	// positions arrive in ring order, so turn pos into an absolute offset
+	abs := 1
+	if h.last > 0 {
+		abs = h.last + (pos-h.lastPos)&(n-1)
+	}
+	h.last, h.lastPos = abs, pos
+
+	var best Match
+	key := h.window[pos : pos+f]
+	hv := h.hash(pos)
+
+	cand := h.head[hv]
+	for depth := 0; cand > 0 && depth < h.depth; depth++ {
+		// only the last n-f positions are still in the window
+		if abs-cand > n-f {
+			break
+		}
+		p := (pos - (abs - cand)) & (n - 1)
+		i := 0
+		for i < f && key[i] == h.window[p+i] {
+			i++
+		}
+		if i > best.Length {
+			best = Match{Position: p, Length: i}
+			if i == f {
+				break
+			}
+		}
+		cand = h.prev[p]
+	}
+
+	h.prev[pos] = h.head[hv]
+	h.head[hv] = abs
+	return best
+}
+
+// Remove does nothing: positions that left the window are skipped in Insert
+func (h *hashChainFinder) Remove(pos int) {}
@@ -0,0 +1,205 @@
+package lzss
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	// frameMagic starts every frame so a reader can resync after corruption
+	frameMagic = 0xC5
+	// frameHeaderSize is the magic byte plus the big-endian payload length
+	// and CRC-32
+	frameHeaderSize = 1 + 4 + 4
+)
+
+// ErrCorruptFrame is returned by FrameReader.ReadFrame for a frame whose
+// payload does not match its length or checksum.
+var ErrCorruptFrame = errors.New("corrupt frame")
+
+// FrameWriter writes messages as length-delimited frames of lzss data:
+//
+//	[magic byte 0xC5][uint32 payload length][uint32 payload CRC-32][lzss payload]
+//
+// Both header fields are big-endian.
+type FrameWriter struct {
+	w io.Writer
+}
+
+// NewFrameWriter returns a FrameWriter writing to w
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// WriteFrame compresses msg and writes it to the underlying writer as one frame
+func (fw *FrameWriter) WriteFrame(msg []byte) error {
+	return AppendCompressed(fw.w, msg)
+}
+
+// AppendCompressed compresses record and writes it to w as one frame, in a
+// single Write. Frames are independent streams, so records appended to a log
+// file over time, even by several processes opening it with os.O_APPEND, leave
+// a file of whole frames that a FrameReader reads back one record at a time.
+func AppendCompressed(w io.Writer, record []byte) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, frameHeaderSize))
+	CompressToBuffer(&buf, record)
+
+	frame := buf.Bytes()
+	frame[0] = frameMagic
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(frame)-frameHeaderSize))
+	binary.BigEndian.PutUint32(frame[5:], crc32.ChecksumIEEE(frame[frameHeaderSize:]))
+
+	if _, err := w.Write(frame); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+	return nil
+}
+
+// FrameReader reads frames written by a FrameWriter
+type FrameReader struct {
+	r rescanReader
+}
+
+// NewFrameReader returns a FrameReader reading from r
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: rescanReader{r: bufio.NewReader(r)}}
+}
+
+// rescanReader reads the bytes of a corrupt frame again before the rest of r
+type rescanReader struct {
+	r      *bufio.Reader
+	rescan []byte
+}
+
+func (rr *rescanReader) Read(p []byte) (int, error) {
+	if len(rr.rescan) > 0 {
+		k := copy(p, rr.rescan)
+		rr.rescan = rr.rescan[k:]
+		return k, nil
+	}
+	return rr.r.Read(p)
+}
+
+// unread puts b back in front of the bytes still to be read
+func (rr *rescanReader) unread(b []byte) {
+	rr.rescan = append(append([]byte(nil), b...), rr.rescan...)
+}
+
+// ReadFrame reads the next frame and returns its decompressed message. Any
+// bytes before the next frame magic are skipped. A frame whose payload is cut
+// short or fails its checksum, for example because its length was corrupted,
+// returns an error wrapping ErrCorruptFrame, and the next call resyncs by
+// looking for a magic from the byte after the corrupt frame's. It returns
+// io.EOF when no frames remain.
+func (fr *FrameReader) ReadFrame() ([]byte, error) {
+	var c [1]byte
+	for {
+		if _, err := io.ReadFull(&fr.r, c[:]); err != nil {
+			return nil, err
+		}
+		if c[0] == frameMagic {
+			break
+		}
+	}
+
+	var hdr [frameHeaderSize - 1]byte
+	if k, err := io.ReadFull(&fr.r, hdr[:]); err != nil {
+		fr.r.unread(hdr[:k])
+		return nil, fmt.Errorf("failed to read frame header: %w", noEOF(err))
+	}
+	length := int64(binary.BigEndian.Uint32(hdr[:]))
+	sum := binary.BigEndian.Uint32(hdr[4:])
+
+	// read through a limit rather than trusting length for one big allocation
+	payload, err := ioutil.ReadAll(io.LimitReader(&fr.r, length))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	if int64(len(payload)) != length || crc32.ChecksumIEEE(payload) != sum {
+		fr.r.unread(append(hdr[:], payload...))
+		return nil, fmt.Errorf("frame of %d bytes with CRC-32 %#08x: %w", length, sum, ErrCorruptFrame)
+	}
+
+	return DecompressWith(nil, payload)
+}
+
+// DecompressParallel decodes src, a run of frames written by a FrameWriter,
+// using up to workers goroutines and returns the messages concatenated in
+// order. Each frame is an independent stream, so they decode concurrently.
+// Unlike ReadFrame it does not resync: src must hold complete frames only.
+func DecompressParallel(src []byte, workers int) ([]byte, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var payloads [][]byte
+	for off := 0; off < len(src); {
+		if len(src)-off < frameHeaderSize {
+			return nil, fmt.Errorf("failed to read frame header at %d: %w", off, io.ErrUnexpectedEOF)
+		}
+		if src[off] != frameMagic {
+			return nil, fmt.Errorf("missing frame magic at %d", off)
+		}
+		length := int64(binary.BigEndian.Uint32(src[off+1:]))
+		sum := binary.BigEndian.Uint32(src[off+5:])
+		off += frameHeaderSize
+		if length > int64(len(src)-off) {
+			return nil, fmt.Errorf("failed to read frame payload at %d: %w", off, io.ErrUnexpectedEOF)
+		}
+		payload := src[off : off+int(length)]
+		if crc32.ChecksumIEEE(payload) != sum {
+			return nil, fmt.Errorf("frame payload at %d: %w", off, ErrCorruptFrame)
+		}
+		payloads = append(payloads, payload)
+		off += int(length)
+	}
+
+	msgs := make([][]byte, len(payloads))
+	errs := make([]error, len(payloads))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				msgs[i], errs[i] = DecompressWith(nil, payloads[i])
+			}
+		}()
+	}
+	for i := range payloads {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var size int
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress frame %d: %w", i, err)
+		}
+		size += len(msgs[i])
+	}
+	dst := make([]byte, 0, size)
+	for _, msg := range msgs {
+		dst = append(dst, msg...)
+	}
+	return dst, nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF for reads that must not end early
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
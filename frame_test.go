@@ -0,0 +1,87 @@
+package lzss
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func writeFrames(t *testing.T, msgs ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf)
+	for _, msg := range msgs {
+		if err := fw.WriteFrame(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	msgs := [][]byte{[]byte("first frame"), {}, bytes.Repeat([]byte("third "), 100)}
+	src := writeFrames(t, msgs...)
+
+	fr := NewFrameReader(bytes.NewReader(src))
+	for i, want := range msgs {
+		got, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("frame %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, err := fr.ReadFrame(); err != io.EOF {
+		t.Errorf("ReadFrame after the last frame = %v, want io.EOF", err)
+	}
+
+	got, err := DecompressParallel(src, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Join(msgs, nil); !bytes.Equal(got, want) {
+		t.Errorf("DecompressParallel = %q, want %q", got, want)
+	}
+}
+
+func TestFrameResync(t *testing.T) {
+	msgs := [][]byte{[]byte("one one one"), []byte("two two two"), []byte("three three")}
+
+	for _, tt := range []struct {
+		name    string
+		corrupt func(src []byte) // corrupts the first frame
+	}{
+		// a longer length swallows the following frames
+		{"length", func(src []byte) { src[1] = 0x01 }},
+		{"checksum", func(src []byte) { src[5] ^= 0xff }},
+		{"payload", func(src []byte) { src[frameHeaderSize+1] ^= 0xff }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src := writeFrames(t, msgs...)
+			tt.corrupt(src)
+
+			fr := NewFrameReader(bytes.NewReader(src))
+			if _, err := fr.ReadFrame(); !errors.Is(err, ErrCorruptFrame) {
+				t.Fatalf("ReadFrame of the corrupt frame = %v, want ErrCorruptFrame", err)
+			}
+			for _, want := range msgs[1:] {
+				got, err := fr.ReadFrame()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("ReadFrame = %q, want %q", got, want)
+				}
+			}
+			if _, err := fr.ReadFrame(); err != io.EOF {
+				t.Errorf("ReadFrame after the last frame = %v, want io.EOF", err)
+			}
+
+			if _, err := DecompressParallel(src, 2); err == nil {
+				t.Error("DecompressParallel accepted a corrupt frame")
+			}
+		})
+	}
+}
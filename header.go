@@ -0,0 +1,308 @@
+package lzss
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"math"
+)
+
+const (
+	// headerSize is the on-disk size of Header: five uint32 fields plus padding
+	headerSize = 5*4 + padding
+
+	compressionType = 0x636f6d70 // "comp"
+	signature       = 0x6c7a7373 // "lzss"
+)
+
+// hasHeaderMagic reports whether data starts with the "complzss" header magic
+func hasHeaderMagic(data []byte) bool {
+	return len(data) >= 8 &&
+		binary.BigEndian.Uint32(data[0:]) == compressionType &&
+		binary.BigEndian.Uint32(data[4:]) == signature
+}
+
+// MarshalBinary encodes the header in its big-endian on-disk layout. Fields are
+// written at fixed offsets so the result never depends on Go's struct layout.
+func (h *Header) MarshalBinary() ([]byte, error) {
+	data := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(data[0:], h.CompressionType)
+	binary.BigEndian.PutUint32(data[4:], h.Signature)
+	binary.BigEndian.PutUint32(data[8:], h.CheckSum)
+	binary.BigEndian.PutUint32(data[12:], h.UncompressedSize)
+	binary.BigEndian.PutUint32(data[16:], h.CompressedSize)
+	copy(data[20:], h.Padding[:])
+	return data, nil
+}
+
+// UnmarshalBinary decodes a header from its big-endian on-disk layout
+func (h *Header) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize {
+		return fmt.Errorf("header needs %d bytes, got %d", headerSize, len(data))
+	}
+	h.CompressionType = binary.BigEndian.Uint32(data[0:])
+	h.Signature = binary.BigEndian.Uint32(data[4:])
+	h.CheckSum = binary.BigEndian.Uint32(data[8:])
+	h.UncompressedSize = binary.BigEndian.Uint32(data[12:])
+	h.CompressedSize = binary.BigEndian.Uint32(data[16:])
+	copy(h.Padding[:], data[20:headerSize])
+	return nil
+}
+
+// ParseHeaderAt decodes the complzss Header at offset in data, for containers
+// that put a prefix such as a length before it. It fails if the header does
+// not fit in data or lacks the complzss magic.
+func ParseHeaderAt(data []byte, offset int) (Header, error) {
+	var hdr Header
+	if offset < 0 || offset > len(data) || len(data)-offset < headerSize {
+		return hdr, fmt.Errorf("header at offset %d needs %d bytes, data is %d bytes", offset, headerSize, len(data))
+	}
+	if !hasHeaderMagic(data[offset:]) {
+		return hdr, fmt.Errorf("missing complzss header magic at offset %d", offset)
+	}
+	err := hdr.UnmarshalBinary(data[offset:])
+	return hdr, err
+}
+
+// CompressFile compresses src and wraps it in a complzss Header recording the
+// sizes and the Adler-32 checksum of src. Any WithOutputAlignment padding goes
+// after the compressed data and is not counted in CompressedSize, and
+// WithLengthFooter is ignored since the header already records the length.
+func CompressFile(src []byte, opts ...Option) []byte {
+	o := mustOptions(opts)
+	align := o.Alignment
+	o.Alignment = 0
+	o.LengthFooter = false
+
+	dat := compressBytes(src, o, nil, nil)
+
+	hdr := Header{
+		CompressionType:  compressionType,
+		Signature:        signature,
+		CheckSum:         adler32.Checksum(src),
+		UncompressedSize: uint32(o.recordedSize(int64(len(src)))),
+		CompressedSize:   uint32(len(dat)),
+	}
+	copy(hdr.Padding[:], o.HeaderPadding)
+	out, _ := hdr.MarshalBinary()
+	out = append(out, dat...)
+	out = append(out, alignPadding(int64(len(dat)), align)...)
+
+	return out
+}
+
+// CompressFileStream writes everything read from src to dst as a complzss
+// file, like CompressFile, without holding the input in memory. The header's
+// sizes and checksum are only known once src is exhausted, so when dst is an
+// io.WriteSeeker space for the header is reserved and filled in afterwards.
+// Any other writer gets the compressed data buffered in memory and written
+// after the header. It returns the number of bytes written to dst.
+func CompressFileStream(dst io.Writer, src io.Reader, opts ...Option) (int64, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	align := o.Alignment
+	o.Alignment = 0
+	o.LengthFooter = false
+
+	// hash and count the input as the encoder reads it
+	sum := adler32.New()
+	cnt := &countWriter{w: sum}
+	in := bufio.NewReader(io.TeeReader(src, cnt))
+	header := func(compressedSize int64) ([]byte, error) {
+		return streamHeader(sum.Sum32(), cnt.n, compressedSize, o)
+	}
+
+	if ws, ok := dst.(io.WriteSeeker); ok {
+		// pipes and terminals can be Seekers that fail to seek
+		if start, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			return compressFileSeek(ws, start, in, o, align, header)
+		}
+	}
+
+	var body bytes.Buffer
+	if _, err := compress(&body, in, o, nil, nil); err != nil {
+		return 0, err
+	}
+	hdr, err := header(int64(body.Len()))
+	if err != nil {
+		return 0, err
+	}
+	out := &countWriter{w: dst}
+	out.Write(hdr)
+	out.Write(body.Bytes())
+	out.Write(alignPadding(int64(body.Len()), align))
+	return out.n, out.err
+}
+
+// compressFileSeek writes a placeholder header at start, the compressed data
+// after it, then seeks back to fill in the header and leaves ws at the end.
+func compressFileSeek(ws io.WriteSeeker, start int64, in io.ByteReader, o Options, align int, header func(int64) ([]byte, error)) (int64, error) {
+	bw := bufio.NewWriter(ws)
+	if _, err := bw.Write(make([]byte, headerSize)); err != nil {
+		return 0, err
+	}
+	size, err := compress(bw, in, o, nil, nil)
+	if err != nil {
+		return headerSize + size, err
+	}
+	pad := alignPadding(size, align)
+	if _, err := bw.Write(pad); err != nil {
+		return headerSize + size, err
+	}
+	if err := bw.Flush(); err != nil {
+		return headerSize + size, err
+	}
+	total := headerSize + size + int64(len(pad))
+
+	hdr, err := header(size)
+	if err != nil {
+		return total, err
+	}
+	if _, err := ws.Seek(start, io.SeekStart); err != nil {
+		return total, fmt.Errorf("failed to seek back to header: %w", err)
+	}
+	if _, err := ws.Write(hdr); err != nil {
+		return total, fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err := ws.Seek(start+total, io.SeekStart); err != nil {
+		return total, fmt.Errorf("failed to seek past compressed data: %w", err)
+	}
+	return total, nil
+}
+
+// streamHeader encodes a complzss header, checking the sizes fit in 32 bits
+func streamHeader(checksum uint32, uncompressedSize, compressedSize int64, o Options) ([]byte, error) {
+	uncompressedSize = o.recordedSize(uncompressedSize)
+	if uncompressedSize > math.MaxUint32 || compressedSize > math.MaxUint32 {
+		return nil, fmt.Errorf("complzss sizes are 32 bits, got %d bytes in and %d out", uncompressedSize, compressedSize)
+	}
+	hdr := Header{
+		CompressionType:  compressionType,
+		Signature:        signature,
+		CheckSum:         checksum,
+		UncompressedSize: uint32(uncompressedSize),
+		CompressedSize:   uint32(compressedSize),
+	}
+	copy(hdr.Padding[:], o.HeaderPadding)
+	return hdr.MarshalBinary()
+}
+
+// recordedSize returns the UncompressedSize a Header records for size bytes
+func (o Options) recordedSize(size int64) int64 {
+	if int64(o.UncompressedSize) > size {
+		return int64(o.UncompressedSize)
+	}
+	return size
+}
+
+// alignPadding returns the zeros that pad size bytes to a multiple of align
+func alignPadding(size int64, align int) []byte {
+	if align > 1 {
+		if rem := int(size % int64(align)); rem != 0 {
+			return make([]byte, align-rem)
+		}
+	}
+	return nil
+}
+
+// DecompressFile decompresses a complzss file: a Header followed by lzss data.
+// Only CompressedSize bytes after the header are decoded, and the result is
+// checked against the header's CheckSum and must not be longer than its
+// UncompressedSize, which some files round up.
+func DecompressFile(src []byte) ([]byte, error) {
+	hdr, err := ParseHeaderAt(src, 0)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(hdr.CompressedSize) > uint64(len(src)-headerSize) {
+		return nil, fmt.Errorf("header says %d compressed bytes, only %d present", hdr.CompressedSize, len(src)-headerSize)
+	}
+	return decompressContainer(&hdr, src[headerSize:headerSize+int(hdr.CompressedSize)])
+}
+
+// DecompressFileStream decodes a complzss file read from src to dst as it is
+// decoded, like DecompressFile but without holding the compressed or
+// decompressed data in memory, and returns the number of bytes written to dst.
+// The header's CheckSum is only checked once all of the output has been
+// written, so on a checksum error dst already holds the bad data.
+func DecompressFileStream(dst io.Writer, src io.Reader) (int64, error) {
+	raw := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, raw); err != nil {
+		return 0, fmt.Errorf("failed to read header: %w", noEOF(err))
+	}
+	hdr, err := ParseHeaderAt(raw, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := adler32.New()
+	payload := &io.LimitedReader{R: src, N: int64(hdr.CompressedSize)}
+	// a zero UncompressedSize would mean no limit, but then the output must be empty
+	max := int64(hdr.UncompressedSize)
+	if max == 0 {
+		max = 1
+	}
+	written, err := DecompressStream(io.MultiWriter(dst, sum), payload, WithMaxOutput(max))
+	switch {
+	case err == ErrOutputTooLarge || err == nil && written > int64(hdr.UncompressedSize):
+		return written, fmt.Errorf("decompressed more than the %d bytes the header says", hdr.UncompressedSize)
+	case err != nil:
+		return written, err
+	case payload.N > 0:
+		return written, fmt.Errorf("failed to read compressed data: %w", io.ErrUnexpectedEOF)
+	case sum.Sum32() != hdr.CheckSum:
+		return written, fmt.Errorf("checksum %#08x does not match header checksum %#08x", sum.Sum32(), hdr.CheckSum)
+	}
+	return written, nil
+}
+
+// decompressContainer decodes the payload of a complzss file and verifies it
+// against hdr.
+func decompressContainer(hdr *Header, src []byte) ([]byte, error) {
+	dat, err := decompress(src, Options{InitialCapacity: int(hdr.UncompressedSize)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	// UncompressedSize may be rounded up, see WithUncompressedSize; the
+	// checksum still covers exactly the decoded bytes
+	if len(dat) > int(hdr.UncompressedSize) {
+		return nil, fmt.Errorf("decompressed %d bytes, header says %d", len(dat), hdr.UncompressedSize)
+	}
+	if sum := adler32.Checksum(dat); sum != hdr.CheckSum {
+		return nil, fmt.Errorf("checksum %#08x does not match header checksum %#08x", sum, hdr.CheckSum)
+	}
+	return dat, nil
+}
+
+// adlerBase is the modulus of Adler-32
+const adlerBase = 65521
+
+// AdlerCombine returns the Adler-32 checksum of the concatenation of two
+// blocks, given the checksum a of the first, the checksum b of the second and
+// the second's length lenB. Checksums of blocks compressed separately can then
+// be joined into the CheckSum of a whole file without a second pass.
+func AdlerCombine(a, b uint32, lenB int) uint32 {
+	rem := uint64(lenB) % adlerBase
+	sum1 := uint64(a & 0xffff)
+	sum2 := rem * sum1 % adlerBase
+	sum1 += uint64(b&0xffff) + adlerBase - 1
+	sum2 += uint64(a>>16) + uint64(b>>16) + adlerBase - rem
+	if sum1 >= adlerBase {
+		sum1 -= adlerBase
+	}
+	if sum1 >= adlerBase {
+		sum1 -= adlerBase
+	}
+	if sum2 >= adlerBase<<1 {
+		sum2 -= adlerBase << 1
+	}
+	if sum2 >= adlerBase {
+		sum2 -= adlerBase
+	}
+	return uint32(sum2<<16 | sum1)
+}
@@ -0,0 +1,100 @@
+package lzss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/adler32"
+	"testing"
+)
+
+func TestFileSingleByte(t *testing.T) {
+	src := []byte{'x'}
+	file := CompressFile(src)
+
+	hdr, err := ParseHeaderAt(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.UncompressedSize != 1 {
+		t.Errorf("UncompressedSize = %d, want 1", hdr.UncompressedSize)
+	}
+	if want := adler32.Checksum(src); hdr.CheckSum != want {
+		t.Errorf("CheckSum = %#08x, want %#08x", hdr.CheckSum, want)
+	}
+	// one flag byte and the literal
+	if hdr.CompressedSize != 2 || len(file) != headerSize+2 {
+		t.Errorf("CompressedSize = %d in a %d byte file, want 2 in %d", hdr.CompressedSize, len(file), headerSize+2)
+	}
+
+	got, err := DecompressFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("DecompressFile = %q, want %q", got, src)
+	}
+}
+
+func TestHeaderLayout(t *testing.T) {
+	if size := binary.Size(Header{}); size != headerSize {
+		t.Fatalf("binary.Size(Header{}) = %d, want %d", size, headerSize)
+	}
+
+	hdr := Header{
+		CompressionType:  compressionType,
+		Signature:        signature,
+		CheckSum:         0x01020304,
+		UncompressedSize: 0x05060708,
+		CompressedSize:   0x090a0b0c,
+	}
+	for i := range hdr.Padding {
+		hdr.Padding[i] = byte(0x80 + i)
+	}
+	data, err := hdr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != headerSize {
+		t.Fatalf("MarshalBinary returned %d bytes, want %d", len(data), headerSize)
+	}
+	want := append([]byte("complzss\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c"), hdr.Padding[:]...)
+	if !bytes.Equal(data, want) {
+		t.Errorf("MarshalBinary = % x, want % x", data, want)
+	}
+
+	var got Header
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got != hdr {
+		t.Errorf("UnmarshalBinary = %+v, want %+v", got, hdr)
+	}
+	if err := got.UnmarshalBinary(data[:headerSize-1]); err == nil {
+		t.Error("UnmarshalBinary accepted a short header")
+	}
+}
+
+func TestFileTrailingBytes(t *testing.T) {
+	for _, src := range corpus() {
+		file := CompressFile(src)
+		// NUL padding to an alignment, then unrelated data
+		for _, tail := range [][]byte{make([]byte, 16-len(file)%16), bytes.Repeat([]byte{0xFF, 'x'}, 100)} {
+			padded := append(append([]byte(nil), file...), tail...)
+			got, err := DecompressFile(padded)
+			if err != nil {
+				t.Fatalf("%d bytes with %d trailing: %v", len(src), len(tail), err)
+			}
+			if !bytes.Equal(got, src) {
+				t.Fatalf("%d bytes with %d trailing did not round trip", len(src), len(tail))
+			}
+
+			var buf bytes.Buffer
+			if _, err := DecompressFileStream(&buf, bytes.NewReader(padded)); err != nil {
+				t.Fatalf("DecompressFileStream of %d bytes with %d trailing: %v", len(src), len(tail), err)
+			}
+			if !bytes.Equal(buf.Bytes(), src) {
+				t.Fatalf("DecompressFileStream of %d bytes with %d trailing did not round trip", len(src), len(tail))
+			}
+		}
+	}
+}
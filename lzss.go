@@ -20,7 +20,17 @@
 
 package lzss
 
-import "bytes"
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
 
 const (
 	// n is the size of ring buffer - must be power of 2
@@ -32,75 +42,586 @@ const (
 	padding   = 0x16c
 )
 
+// Matches are a 4-bit length field holding threshold+1 to f and a 12-bit
+// position into the ring. Constants the format cannot encode make these array
+// lengths negative, so they fail to compile instead of corrupting output.
+var (
+	_ [15 - (f - (threshold + 1))]struct{}
+	_ [1<<12 - n]struct{}
+)
+
+// ErrUnknownSize is returned by DecodedLen for streams that do not record their
+// decoded size; it can only be found by decoding them.
+var ErrUnknownSize = errors.New("decoded size is not recorded in the stream")
+
+// ErrOutputTooLarge is returned when decoding would write more than the
+// WithMaxOutput limit.
+var ErrOutputTooLarge = errors.New("decompressed output exceeds the size limit")
+
+// ScratchSize is the size of the ring buffer a decoder needs; see DecompressWith
+const ScratchSize = n + f - 1
+
 // Header represents the LZSS header
 type Header struct {
 	CompressionType  uint32 // 0x636f6d70 "comp"
 	Signature        uint32 // 0x6c7a7373 "lzss"
-	CheckSum         uint32 // Likely CRC32
+	CheckSum         uint32 // Adler-32 of the uncompressed data
 	UncompressedSize uint32
 	CompressedSize   uint32
 	Padding          [padding]byte
 }
 
-// Decompress decompresses lzss data
-func Decompress(src []byte) []byte {
+// Decompress decompresses lzss data. Any input decodes to something, so raw
+// data passed by mistake comes back as garbage; see LooksCompressed.
+func Decompress(src []byte, opts ...Option) []byte {
+	o := mustOptions(opts)
 
-	var i, j, r, c int
-	var flags uint
+	var start time.Time
+	if o.Metrics != nil {
+		start = time.Now()
+	}
 
-	srcBuf := bytes.NewBuffer(src)
+	scratch := scratchPool.Get().(*[ScratchSize]byte)
+	defer scratchPool.Put(scratch)
+	d := newDecoderBytes(src, scratch[:])
+	d.setOptions(o)
 	dst := bytes.Buffer{}
+	dst.Grow(o.capacity(2 * len(src)))
 
-	// ring buffer of size n, with extra f-1 bytes to aid string comparison
-	textBuf := make([]byte, n+f-1)
+	for {
+		tok, err := d.next()
+		if err != nil {
+			break
+		}
+		dst.Write(tok)
+	}
 
-	r = n - f
-	flags = 0
+	if o.Metrics != nil {
+		o.Metrics.record(d.pos, dst.Len(), d.groups, start)
+	}
+
+	return dst.Bytes()
+}
+
+// DecompressWith decompresses lzss data using scratch as the decoder's ring
+// buffer, avoiding an allocation per call when decoding many small blobs.
+// scratch must hold at least ScratchSize bytes, otherwise a buffer is allocated
+// internally. The output never aliases scratch.
+func DecompressWith(scratch, src []byte) ([]byte, error) {
+
+	d := newDecoderBuf(bytes.NewReader(src), scratch)
+	dst := bytes.Buffer{}
 
 	for {
-		flags = flags >> 1
-		if ((flags) & 0x100) == 0 {
-			bite, err := srcBuf.ReadByte()
-			if err != nil {
-				break
-			}
-			c = int(bite)
-			flags = uint(c | 0xFF00) /* uses higher byte cleverly to count eight*/
+		tok, err := d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return dst.Bytes(), err
+		}
+		dst.Write(tok)
+	}
+
+	return dst.Bytes(), nil
+}
+
+// maxPooledResult is the largest buffer a Result returns to its pool, so one
+// huge decode does not pin its memory for good
+const maxPooledResult = 1 << 20
+
+var resultPool = sync.Pool{
+	New: func() interface{} {
+		return new(Result)
+	},
+}
+
+// Result holds the output of DecompressView in a pooled buffer
+type Result struct {
+	buf []byte
+}
+
+// Bytes returns the decoded data. It must not be modified, and it is only
+// valid until Release is called.
+func (r *Result) Bytes() []byte {
+	return r.buf
+}
+
+// Release returns the buffer to the pool for reuse by a later DecompressView.
+// Using r or any slice from Bytes afterwards is undefined: the data may be
+// overwritten at any time.
+func (r *Result) Release() {
+	if cap(r.buf) > maxPooledResult {
+		r.buf = nil
+	}
+	r.buf = r.buf[:0]
+	resultPool.Put(r)
+}
+
+// DecompressView decompresses lzss data like DecompressWith, but into a buffer
+// taken from a pool, for hot paths that decode data, inspect it and throw it
+// away. Call Release on the Result when done with the data so the next call can
+// reuse the buffer instead of allocating. Buffers larger than 1 MiB are not
+// kept.
+func DecompressView(src []byte, opts ...Option) (*Result, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	scratch := scratchPool.Get().(*[ScratchSize]byte)
+	defer scratchPool.Put(scratch)
+	d := newDecoderBytes(src, scratch[:])
+	d.setOptions(o)
+
+	res := resultPool.Get().(*Result)
+	for {
+		tok, err := d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			res.Release()
+			return nil, err
+		}
+		res.buf = append(res.buf, tok...)
+	}
+	return res, nil
+}
+
+// DecompressConcat decodes each part as a separate lzss stream, each with a
+// fresh ring buffer, and returns their outputs joined in order. A part can
+// never match against bytes of an earlier part, so the parts of an
+// uncompressed whole must each be compressed on their own for this to work;
+// the compressed bytes themselves cannot just be concatenated.
+func DecompressConcat(parts ...[]byte) ([]byte, error) {
+	scratch := make([]byte, ScratchSize)
+	dst := bytes.Buffer{}
+	out := &countWriter{w: &dst}
+
+	for i, part := range parts {
+		d := newDecoderBuf(bytes.NewReader(part), scratch)
+		if err := decodeTo(out, d, 0); err != nil {
+			return nil, fmt.Errorf("part %d: %w", i, err)
+		}
+	}
+
+	return dst.Bytes(), nil
+}
+
+// DecompressMarked decodes src as lzss streams written one after another,
+// each ending in the marker written by WithEndMarker, and returns each
+// stream's output separately. The markers find the boundaries, so no length
+// prefixes are needed, but the streams must not have alignment padding. With
+// WithLengthFooter each marker is followed by its stream's footer. Data after
+// the last marker that does not end in one is an error. opts are the decoder
+// options, with WithEndMarker implied.
+func DecompressMarked(src []byte, opts ...Option) ([][]byte, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	o.EndMarker = true
+
+	scratch := make([]byte, ScratchSize)
+	var segs [][]byte
+	for pos := 0; pos < len(src); {
+		d := newDecoderBuf(bytes.NewReader(src[pos:]), scratch)
+		d.setOptions(o)
+		dst := bytes.Buffer{}
+		if err := decodeTo(&countWriter{w: &dst}, d, 0); err != nil {
+			return segs, fmt.Errorf("segment %d at %d: %w", len(segs), pos, err)
+		}
+		if !d.ended {
+			return segs, fmt.Errorf("segment %d at %d has no end marker: %w", len(segs), pos, io.ErrUnexpectedEOF)
+		}
+		segs = append(segs, dst.Bytes())
+		pos += d.pos
+		if o.LengthFooter {
+			pos += footerSize
+		}
+	}
+	return segs, nil
+}
+
+// DecompressWithFooter decompresses lzss data written with WithLengthFooter. The
+// footer is used to size the output up front, to verify the decoded length and
+// to skip any alignment padding before it.
+func DecompressWithFooter(src []byte) ([]byte, error) {
+	return decompress(src, Options{LengthFooter: true})
+}
+
+// decompress decodes src as written by Compress with the same options,
+// returning an error if the stream is truncated or its footer does not match.
+func decompress(src []byte, o Options) ([]byte, error) {
+	src, size, err := splitFooter(src, o)
+	if err != nil {
+		return nil, err
+	}
+	o.LengthFooter = false
+
+	capacity := o.capacity(2 * len(src))
+	if size >= 0 {
+		capacity = size
+	}
+	// never trust a recorded size for more than the stream could expand to
+	if max := maxExpansion(len(src)); capacity > max {
+		capacity = max
+	}
+
+	scratch := scratchPool.Get().(*[ScratchSize]byte)
+	defer scratchPool.Put(scratch)
+	d := newDecoderBytes(src, scratch[:])
+	d.setOptions(o)
+	dst := make([]byte, 0, capacity)
+
+	// with a footer, stop at the length it records, which also skips any
+	// alignment padding before it
+	for size < 0 || len(dst) < size {
+		tok, err := d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return dst, err
+		}
+		dst = append(dst, tok...)
+	}
+
+	if size >= 0 && len(dst) != size {
+		return dst, fmt.Errorf("decoded %d bytes, footer says %d", len(dst), size)
+	}
+	if size >= 0 && d.pos < d.padAt {
+		return dst, fmt.Errorf("data continues after the %d bytes the footer records", size)
+	}
+
+	return dst, nil
+}
+
+// splitFooter strips the length footer from src when o expects one, returning
+// the length it records, or -1 when there is no footer.
+func splitFooter(src []byte, o Options) ([]byte, int, error) {
+	if !o.LengthFooter {
+		return src, -1, nil
+	}
+	if len(src) < footerSize {
+		return nil, 0, fmt.Errorf("data too short for a %d byte length footer", footerSize)
+	}
+	size := int(binary.BigEndian.Uint32(src[len(src)-footerSize:]))
+	return src[:len(src)-footerSize], size, nil
+}
+
+// DecompressAt decompresses the length bytes that start at offset in the
+// decompressed output of src. LZSS streams have no independent sync points, so
+// everything before offset must still be decoded; it is just not kept. This
+// makes DecompressAt O(offset+length) in time but only O(length) in memory.
+func DecompressAt(src []byte, offset, length int) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid offset %d or length %d", offset, length)
+	}
+
+	d := newDecoder(src)
+	dst := make([]byte, 0, length)
+
+	pos := 0
+	for len(dst) < length {
+		tok, err := d.next()
+		if err == io.EOF {
+			return dst, fmt.Errorf("decoded output ended at %d before offset+length %d: %w", pos, offset+length, io.ErrUnexpectedEOF)
+		} else if err != nil {
+			return dst, fmt.Errorf("failed to decompress: %w", err)
+		}
+		start := pos
+		pos += len(tok)
+		if pos <= offset {
+			continue
+		}
+		if start < offset {
+			tok = tok[offset-start:]
 		}
-		if flags&1 == 1 {
-			bite, err := srcBuf.ReadByte()
-			if err != nil {
-				break
+		if rem := length - len(dst); len(tok) > rem {
+			tok = tok[:rem]
+		}
+		dst = append(dst, tok...)
+	}
+
+	return dst, nil
+}
+
+// DecompressPrefix decodes only the first k bytes of src's output, such as a
+// header inside a large payload, and stops there instead of decoding the rest.
+// A match that runs past the kth byte is cut short. If the whole output is
+// shorter than k it is returned as is, without an error. Unlike DecompressAt it
+// takes decoder options.
+func DecompressPrefix(src []byte, k int, opts ...Option) ([]byte, error) {
+	if k < 0 {
+		return nil, fmt.Errorf("invalid prefix length %d", k)
+	}
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	// never trust k for more than the stream could expand to
+	capacity := k
+	if max := maxExpansion(len(src)); capacity > max {
+		capacity = max
+	}
+
+	d := newDecoder(src)
+	d.setOptions(o)
+	dst := make([]byte, 0, capacity)
+	for len(dst) < k {
+		tok, err := d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return dst, err
+		}
+		if rem := k - len(dst); len(tok) > rem {
+			tok = tok[:rem]
+		}
+		dst = append(dst, tok...)
+	}
+	return dst, nil
+}
+
+// DecompressReaderAt decompresses the length bytes of lzss data stored at off
+// in r, such as a compressed member inside a larger firmware image, without
+// reading the rest of r.
+func DecompressReaderAt(r io.ReaderAt, off, length int64) ([]byte, error) {
+	if off < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid offset %d or length %d", off, length)
+	}
+
+	src := make([]byte, length)
+	// ReadAt may report io.EOF alongside a full read at the end of r
+	if n, err := r.ReadAt(src, off); n < len(src) {
+		return nil, fmt.Errorf("failed to read compressed data at %#x: %w", off, err)
+	}
+
+	return DecompressWith(nil, src)
+}
+
+// Recompress decodes src, which was compressed with the from options, and
+// compresses the result again with the to options. This migrates stored data
+// to a new configuration in one call. The decoder feeds the encoder directly,
+// so the decompressed data is never held in memory as a whole.
+func Recompress(src []byte, from, to Options) ([]byte, error) {
+	if err := from.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid from options: %w", err)
+	}
+	if err := to.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid to options: %w", err)
+	}
+	src, size, err := splitFooter(src, from)
+	if err != nil {
+		return nil, err
+	}
+	from.LengthFooter = false
+
+	d := newDecoder(src)
+	d.setOptions(from)
+	dst := bytes.Buffer{}
+	dst.Grow(to.capacity(len(src)))
+
+	if _, err := compress(&dst, d, to, nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	if size >= 0 && d.offset != size {
+		return nil, fmt.Errorf("decoded %d bytes, footer says %d", d.offset, size)
+	}
+
+	return dst.Bytes(), nil
+}
+
+// DecompressStream decompresses everything read from src to dst, writing
+// output as it is decoded so memory use stays fixed no matter how long the
+// stream is. It returns the number of bytes written to dst.
+func DecompressStream(dst io.Writer, src io.Reader, opts ...Option) (int64, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+
+	br := bufio.NewReader(src)
+	if p := o.peekSize(); p > br.Size() {
+		br = bufio.NewReaderSize(src, p)
+	}
+	d := newDecoderBuf(br, nil)
+	d.setOptions(o)
+	bw := bufio.NewWriter(dst)
+	out := &countWriter{w: bw}
+
+	if err := decodeTo(out, d, o.MaxOutput); err != nil {
+		return out.n, err
+	}
+	return out.n, bw.Flush()
+}
+
+// DecompressFunc decompresses everything read from src using no memory beyond
+// the decoder's ring buffer. Decoded bytes stay in the ring until a match may
+// still need them, and are handed to fn in chunks just before they would be
+// overwritten, plus a final chunk at the end. A chunk is only valid during the
+// call to fn; an error from fn stops decoding and is returned. DecompressFunc
+// returns the number of bytes passed to fn.
+func DecompressFunc(src io.Reader, fn func(chunk []byte) error, opts ...Option) (int64, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+
+	br, ok := src.(io.ByteReader)
+	if !ok {
+		buf := bufio.NewReader(src)
+		if p := o.peekSize(); p > buf.Size() {
+			buf = bufio.NewReaderSize(src, p)
+		}
+		br = buf
+	}
+	d := newDecoderBuf(br, nil)
+	d.setOptions(o)
+
+	// the ring holds the decoded bytes from start up to d.r, not yet given to fn
+	start := d.r
+	var written int64
+	emit := func() error {
+		for start != d.r {
+			end := d.r
+			if end < start {
+				end = n
 			}
-			c = int(bite)
-			dst.WriteByte(byte(c))
-			textBuf[r] = byte(c)
-			r++
-			r &= (n - 1)
-		} else {
-			bite, err := srcBuf.ReadByte()
-			if err != nil {
-				break
+			if err := fn(d.textBuf[start:end]); err != nil {
+				return err
 			}
-			i = int(bite)
+			written += int64(end - start)
+			start = end & (n - 1)
+		}
+		return nil
+	}
 
-			bite, err = srcBuf.ReadByte()
-			if err != nil {
-				break
-			}
-			j = int(bite)
-
-			i |= ((j & 0xF0) << 4)
-			j = (j & 0x0F) + threshold
-			for k := 0; k <= j; k++ {
-				c = int(textBuf[(i+k)&(n-1)])
-				dst.WriteByte(byte(c))
-				textBuf[r] = byte(c)
-				r++
-				r &= (n - 1)
+	for {
+		_, err := d.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return written, fmt.Errorf("failed to decompress: %w", err)
+		}
+		// the next token writes at most f bytes, so emit before it could
+		// overwrite bytes fn has not seen
+		if (d.r-start)&(n-1) >= n-f {
+			if err := emit(); err != nil {
+				return written, err
 			}
 		}
 	}
 
-	return dst.Bytes()
+	return written, emit()
+}
+
+// DecompressTo decompresses src to dst as it is decoded, without holding the
+// output in memory, and returns the number of bytes written to dst. With
+// WithMaxOutput it stops before writing past the limit.
+func DecompressTo(dst io.Writer, src []byte, opts ...Option) (int64, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+
+	d := newDecoder(src)
+	d.setOptions(o)
+	out := &countWriter{w: dst}
+
+	err := decodeTo(out, d, o.MaxOutput)
+	return out.n, err
+}
+
+// VerifyDecodeHash decodes src through h, after resetting it, and reports
+// whether the digest of the output equals want. The output is hashed as it is
+// decoded and never held in memory, which makes this cheaper than
+// decompressing and comparing when only integrity matters. An error means src
+// did not decode, as opposed to decoding to the wrong data.
+func VerifyDecodeHash(src []byte, h hash.Hash, want []byte, opts ...Option) (bool, error) {
+	h.Reset()
+	if _, err := DecompressTo(h, src, opts...); err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), want), nil
+}
+
+// decodeTo writes everything d decodes to out, failing with ErrOutputTooLarge
+// before a write would take out past max bytes (0 means no limit).
+func decodeTo(out *countWriter, d *decoder, max int64) error {
+	for {
+		tok, err := d.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decompress: %w", err)
+		}
+		if max > 0 && out.n+int64(len(tok)) > max {
+			return ErrOutputTooLarge
+		}
+		if _, err := out.Write(tok); err != nil {
+			return err
+		}
+	}
+}
+
+// DecompressRecover decompresses lzss data like DecompressWith, but converts any
+// panic raised while decoding into an error so a single malformed input cannot
+// crash the caller.
+func DecompressRecover(src []byte) (out []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			out = nil
+			err = fmt.Errorf("panic decompressing %d bytes of input: %v", len(src), r)
+		}
+	}()
+	return decompress(src, Options{})
+}
+
+// DecodedLen returns the decompressed size of src without decoding it, using
+// the complzss Header or, when WithLengthFooter is given, the length footer.
+// It returns ErrUnknownSize for raw streams that carry neither.
+func DecodedLen(src []byte, opts ...Option) (int, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	if hasHeaderMagic(src) {
+		var hdr Header
+		if err := hdr.UnmarshalBinary(src); err != nil {
+			return 0, err
+		}
+		return int(hdr.UncompressedSize), nil
+	}
+	if o.LengthFooter {
+		_, size, err := splitFooter(src, o)
+		return size, err
+	}
+	return 0, ErrUnknownSize
+}
+
+// TrimStream returns the prefix of src that decodes to exactly size bytes,
+// dropping whatever follows it, such as alignment padding or bytes left over
+// from extracting a blob with too large a length. Raw lzss has no end marker,
+// so the decoded size must come from elsewhere, e.g. a Header, DecodedLen or
+// a length recorded by the container. The options select the token format.
+func TrimStream(src []byte, size int, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+
+	d := newDecoder(src)
+	d.setOptions(o)
+	for d.offset < size {
+		if _, err := d.next(); err != nil {
+			return nil, fmt.Errorf("stream ended at %d decoded bytes before size %d: %w", d.offset, size, noEOF(err))
+		}
+	}
+	if d.offset != size {
+		return nil, fmt.Errorf("last token ends at %d decoded bytes, past size %d", d.offset, size)
+	}
+	return src[:d.pos], nil
 }
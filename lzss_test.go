@@ -0,0 +1,192 @@
+package lzss
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// corpus returns inputs of assorted sizes and kinds: empty, tiny, runs, text,
+// random bytes, zeros and every length below 100
+func corpus() [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	c := [][]byte{nil, {1}, []byte("ab"), bytes.Repeat([]byte{0x41}, 1000), bytes.Repeat([]byte("hello world "), 2000)}
+	random := make([]byte, 10000)
+	rnd.Read(random)
+	c = append(c, random)
+	text := make([]byte, 50000)
+	for i := range text {
+		text[i] = "abcdefgh  \n"[rnd.Intn(11)]
+	}
+	c = append(c, text, make([]byte, 70000))
+	for l := 0; l < 100; l++ {
+		b := make([]byte, l)
+		for i := range b {
+			b[i] = byte(rnd.Intn(3))
+		}
+		c = append(c, b)
+	}
+	return c
+}
+
+func TestAlignedRoundTrip(t *testing.T) {
+	type input struct {
+		src  []byte
+		opts []Option // on top of the alignment and flag polarity
+	}
+	var inputs []input
+	for _, src := range corpus() {
+		inputs = append(inputs, input{src: src})
+	}
+	// streams that would end in zero bytes before the padding: a last match
+	// of three bytes from ring position 0, and with inverted flags a last
+	// group of eight zero literals
+	inputs = append(inputs,
+		input{src: []byte("aaabaabbaaabaabababbbb")},
+		input{src: append(bytes.Repeat([]byte("x"), 8), make([]byte, 8)...), opts: []Option{WithLiteralsOnly(true)}},
+	)
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 500; i++ {
+		src := make([]byte, rnd.Intn(40))
+		for i := range src {
+			src[i] = "ab"[rnd.Intn(2)]
+		}
+		inputs = append(inputs, input{src: src})
+	}
+
+	for _, in := range inputs {
+		src := in.src
+		for _, a := range []int{2, 4, 16, 32, 512} {
+			for _, inv := range []bool{false, true} {
+				opts := append([]Option{WithOutputAlignment(a), WithInvertedFlags(inv)}, in.opts...)
+				dst := Compress(src, opts...)
+				if len(dst)%a != 0 {
+					t.Fatalf("%d bytes aligned to %d: compressed length %d", len(src), a, len(dst))
+				}
+				if got := Decompress(dst, opts...); !bytes.Equal(got, src) {
+					t.Fatalf("%d bytes aligned to %d: Decompress returned %d bytes", len(src), a, len(got))
+				}
+				got, err := ioutil.ReadAll(NewReaderSize(bytes.NewReader(dst), 1024, opts...))
+				if err != nil || !bytes.Equal(got, src) {
+					t.Fatalf("%d bytes aligned to %d: NewReader returned %d bytes: %v", len(src), a, len(got), err)
+				}
+			}
+		}
+	}
+}
+
+func TestLengthFooter(t *testing.T) {
+	for _, a := range []int{0, 16} {
+		footer := []Option{WithLengthFooter(true), WithOutputAlignment(a)}
+		for _, src := range corpus() {
+			dst := Compress(src, footer...)
+			if a > 0 && len(dst)%a != 0 {
+				t.Fatalf("%d bytes aligned to %d: compressed length %d", len(src), a, len(dst))
+			}
+
+			if got := Decompress(dst, footer...); !bytes.Equal(got, src) {
+				t.Errorf("align %d: Decompress of %d bytes returned %d", a, len(src), len(got))
+			}
+			if got, err := DecompressWithFooter(dst); err != nil || !bytes.Equal(got, src) {
+				t.Errorf("align %d: DecompressWithFooter of %d bytes returned %d: %v", a, len(src), len(got), err)
+			}
+			if size, err := DecodedLen(dst, footer...); err != nil || size != len(src) {
+				t.Errorf("align %d: DecodedLen of %d bytes = %d, %v", a, len(src), size, err)
+			}
+			var buf bytes.Buffer
+			if _, err := DecompressTo(&buf, dst, footer...); err != nil || !bytes.Equal(buf.Bytes(), src) {
+				t.Errorf("align %d: DecompressTo of %d bytes returned %d: %v", a, len(src), buf.Len(), err)
+			}
+			buf.Reset()
+			if _, err := DecompressStream(&buf, bytes.NewReader(dst), footer...); err != nil || !bytes.Equal(buf.Bytes(), src) {
+				t.Errorf("align %d: DecompressStream of %d bytes returned %d: %v", a, len(src), buf.Len(), err)
+			}
+			got, err := ioutil.ReadAll(NewReaderSize(bytes.NewReader(dst), 1024, footer...))
+			if err != nil || !bytes.Equal(got, src) {
+				t.Errorf("align %d: NewReaderSize of %d bytes returned %d: %v", a, len(src), len(got), err)
+			}
+			_, dec := CompressWithDecoder(src, footer...)
+			if got, err := dec.Decode(); err != nil || !bytes.Equal(got, src) {
+				t.Errorf("align %d: Decoder.Decode of %d bytes returned %d: %v", a, len(src), len(got), err)
+			}
+			if got, err := ioutil.ReadAll(dec.Reader()); err != nil || !bytes.Equal(got, src) {
+				t.Errorf("align %d: Decoder.Reader of %d bytes returned %d: %v", a, len(src), len(got), err)
+			}
+
+			// a footer that disagrees with the data is an error, not extra output
+			bad := append([]byte(nil), dst...)
+			bad[len(bad)-1]++
+			if _, err := DecompressWithFooter(bad); err == nil {
+				t.Errorf("align %d: DecompressWithFooter of %d bytes accepted a wrong footer", a, len(src))
+			}
+			if _, err := DecompressTo(ioutil.Discard, bad, footer...); err == nil {
+				t.Errorf("align %d: DecompressTo of %d bytes accepted a wrong footer", a, len(src))
+			}
+			if _, err := DecompressStream(ioutil.Discard, bytes.NewReader(bad), footer...); err == nil {
+				t.Errorf("align %d: DecompressStream of %d bytes accepted a wrong footer", a, len(src))
+			}
+			if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(bad), footer...)); err == nil {
+				t.Errorf("align %d: NewReader of %d bytes accepted a wrong footer", a, len(src))
+			}
+		}
+	}
+
+	var de *DecodeError
+	_, err := DecompressTo(ioutil.Discard, []byte{0xFF, 'a', 0}, WithLengthFooter(true))
+	if !errors.As(err, &de) || de.Kind != "footer" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated footer: got %v, want a footer DecodeError wrapping io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDecompressAtErrors(t *testing.T) {
+	src := Compress([]byte("hello hello hello"))
+
+	_, err := DecompressAt(src, 10, 100)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("short output: got %v, want io.ErrUnexpectedEOF", err)
+	}
+	var de *DecodeError
+	if errors.As(err, &de) {
+		t.Errorf("short output reported as a decode error: %v", err)
+	}
+
+	// a match cut short is a malformed stream, not short output
+	_, err = DecompressAt([]byte{0x00, 0x00}, 0, 10)
+	if !errors.As(err, &de) || de.Kind != "match" {
+		t.Errorf("truncated match: got %v, want a match DecodeError", err)
+	}
+}
+
+func BenchmarkDecompressTiny(b *testing.B) {
+	src := Compress([]byte("tiny"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Decompress(src)
+	}
+}
+
+func TestZeroPadding(t *testing.T) {
+	for _, src := range corpus() {
+		dst := Compress(src)
+		for _, pad := range []int{1, 16 - len(dst)%16, 512} {
+			padded := append(append([]byte(nil), dst...), make([]byte, pad)...)
+			if got := Decompress(padded, WithZeroPadding(true)); !bytes.Equal(got, src) {
+				t.Fatalf("%d bytes with %d zeros of padding did not round trip", len(src), pad)
+			}
+			var buf bytes.Buffer
+			if _, err := DecompressTo(&buf, padded, WithZeroPadding(true)); err != nil || !bytes.Equal(buf.Bytes(), src) {
+				t.Fatalf("%d bytes with %d zeros of padding: %v", len(src), pad, err)
+			}
+		}
+	}
+
+	// without the option the zeros decode as matches
+	src := []byte("hello, world")
+	padded := append(Compress(src), make([]byte, 16)...)
+	if got := Decompress(padded); bytes.Equal(got, src) {
+		t.Error("padding decoded to nothing without WithZeroPadding")
+	}
+}
@@ -0,0 +1,48 @@
+package lzss
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics accumulates statistics across Compress or Decompress calls made with
+// WithMetrics, e.g. for export to a monitoring system. Use separate Metrics for
+// compression and decompression. It is safe for concurrent use.
+type Metrics struct {
+	mu    sync.Mutex
+	stats MetricsSnapshot
+}
+
+// MetricsSnapshot is a point-in-time copy of the counters in a Metrics
+type MetricsSnapshot struct {
+	Calls    int64         // number of calls recorded
+	BytesIn  int64         // total bytes read from src
+	BytesOut int64         // total bytes produced
+	Groups   int64         // total flag-byte groups written or read
+	Duration time.Duration // total time spent in the calls
+}
+
+// Snapshot returns the current counters
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Reset zeroes the counters
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	m.stats = MetricsSnapshot{}
+	m.mu.Unlock()
+}
+
+func (m *Metrics) record(in, out, groups int, start time.Time) {
+	d := time.Since(start)
+	m.mu.Lock()
+	m.stats.Calls++
+	m.stats.BytesIn += int64(in)
+	m.stats.BytesOut += int64(out)
+	m.stats.Groups += int64(groups)
+	m.stats.Duration += d
+	m.mu.Unlock()
+}
@@ -0,0 +1,443 @@
+package lzss
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// Packing selects how a match's 12-bit position and 4-bit length share its two bytes
+type Packing int
+
+const (
+	// PackPositionHigh puts the top 4 position bits in the high nibble of the
+	// second byte and the length in the low nibble. This is the default used
+	// by Okumura's LZSS and Apple's complzss.
+	PackPositionHigh Packing = iota
+	// PackLengthHigh puts the length in the high nibble of the second byte and
+	// the top 4 position bits in the low nibble.
+	PackLengthHigh
+)
+
+// Parsing selects how the encoder chooses between literals and matches
+type Parsing int
+
+const (
+	// Greedy always sends the longest match at the current position. It is
+	// the default and the fastest.
+	Greedy Parsing = iota
+	// Lazy sends a literal instead of a match when a longer match starts at
+	// the next byte.
+	Lazy
+	// Optimal picks the smallest encoding of each block of input given the
+	// longest match at every position. It is the slowest.
+	Optimal
+)
+
+// Options configures the encoder and decoder
+type Options struct {
+	// MaxDistance caps how far back a match may reference (0 means the whole window)
+	MaxDistance int
+	// Alignment pads the output with zeros to a multiple of this many bytes (0 means no padding)
+	Alignment int
+	// LengthFooter appends the uncompressed length as a 4-byte big-endian footer
+	LengthFooter bool
+	// LiteralsOnly sends every byte as a literal and never emits a match
+	LiteralsOnly bool
+	// Metrics, when non-nil, accumulates statistics for each call
+	Metrics *Metrics
+	// Packing is the nibble order of match tokens
+	Packing Packing
+	// RecordBoundaries are sorted input offsets that no match may reach across
+	RecordBoundaries []int
+	// InitialCapacity pre-sizes the output buffer (0 means a guess based on the input size)
+	InitialCapacity int
+	// Parsing is the match selection strategy (Greedy by default)
+	Parsing Parsing
+	// UncompressedSize, when larger than the input, is the UncompressedSize
+	// recorded in a complzss Header instead of the input length
+	UncompressedSize int64
+	// HeaderPadding fills the start of a complzss Header's padding (the rest is zeros)
+	HeaderPadding []byte
+	// InvertFlags makes a 1 flag bit mean a match and a 0 a literal
+	InvertFlags bool
+	// EndMarker ends the stream with a token that tells the decoder to stop
+	EndMarker bool
+	// LiteralRuns sends long stretches of literals as counted runs
+	LiteralRuns bool
+	// Strict makes decoders reject tokens a conforming encoder never emits
+	Strict bool
+	// Logger, when non-nil, receives a line for every token the encoder sends
+	Logger *log.Logger
+	// MaxOutput caps the bytes DecompressTo and DecompressStream write (0 means no cap)
+	MaxOutput int64
+	// MinMatch is the shortest match the encoder sends (0 means threshold+1)
+	MinMatch int
+	// ZeroPadding makes in-memory decoders stop at zero bytes ending the input
+	ZeroPadding bool
+	// FlushGroups makes CompressStream write each flag byte group as soon as it is complete
+	FlushGroups bool
+	// RingSnapshot is data preceding the input that matches may reference
+	RingSnapshot []byte
+
+	// stats receives the statistics of a CompressStats call
+	stats *Stats
+	// window, when non-nil, is reused as the encoder's ring buffer
+	window []byte
+	// hook supplies matches for CompressWithHook
+	hook MatchHook
+}
+
+// DefaultOptions returns the built-in configuration, to copy and modify before
+// passing to CompressWithOptions. The stream format itself is fixed: a 4096
+// byte window, matches of 3 to 18 bytes (threshold 2) and a 12-bit position
+// with a 4-bit length per match. The zero Options is valid and equal to
+// DefaultOptions: no distance cap, no padding or footer, PackPositionHigh
+// packing and Greedy parsing.
+func DefaultOptions() Options {
+	return Options{
+		Packing: PackPositionHigh,
+		Parsing: Greedy,
+	}
+}
+
+// Option sets an encoder or decoder option
+type Option func(*Options)
+
+func newOptions(opts []Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// mustOptions is newOptions for entry points that cannot return an error; it
+// panics on invalid options, which are a programming error.
+func mustOptions(opts []Option) Options {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		panic("lzss: " + err.Error())
+	}
+	return o
+}
+
+// Validate checks the options against each other and against the stream
+// format: a 12-bit match position into an n byte window and a 4-bit length.
+// Entry points that return an error return Validate's; the others, such as
+// Compress and Decompress, panic on invalid options.
+func (o Options) Validate() error {
+	switch {
+	case o.MaxDistance < 0 || o.MaxDistance > n:
+		return fmt.Errorf("max distance %d outside the %d byte window", o.MaxDistance, n)
+	case o.Alignment < 0:
+		return fmt.Errorf("negative output alignment %d", o.Alignment)
+	case o.InitialCapacity < 0:
+		return fmt.Errorf("negative initial capacity %d", o.InitialCapacity)
+	case o.UncompressedSize < 0 || o.UncompressedSize > math.MaxUint32:
+		return fmt.Errorf("uncompressed size %d does not fit a complzss header", o.UncompressedSize)
+	case o.MinMatch != 0 && (o.MinMatch < threshold+1 || o.MinMatch > f):
+		return fmt.Errorf("min match %d outside %d to %d", o.MinMatch, threshold+1, f)
+	case o.MaxOutput < 0:
+		return fmt.Errorf("negative max output %d", o.MaxOutput)
+	case o.Packing != PackPositionHigh && o.Packing != PackLengthHigh:
+		return fmt.Errorf("unknown packing %d", o.Packing)
+	case o.Parsing < Greedy || o.Parsing > Optimal:
+		return fmt.Errorf("unknown parsing %d", o.Parsing)
+	case len(o.RingSnapshot) > n-f:
+		return fmt.Errorf("ring snapshot is %d bytes, at most %d fit", len(o.RingSnapshot), n-f)
+	case len(o.HeaderPadding) > padding:
+		return fmt.Errorf("header padding is %d bytes, at most %d fit", len(o.HeaderPadding), padding)
+	}
+	for i, b := range o.RecordBoundaries {
+		if b < 0 {
+			return fmt.Errorf("negative record boundary %d", b)
+		}
+		if i > 0 && b < o.RecordBoundaries[i-1] {
+			return fmt.Errorf("record boundaries are not sorted at index %d", i)
+		}
+	}
+	return nil
+}
+
+// Equal reports whether o and p hold the same settings, for using options as
+// part of a cache key. RecordBoundaries, HeaderPadding and RingSnapshot are
+// compared by content, Metrics and Logger by identity. Options is not
+// comparable with == because of its slice fields.
+func (o Options) Equal(p Options) bool {
+	if len(o.RecordBoundaries) != len(p.RecordBoundaries) || !bytes.Equal(o.HeaderPadding, p.HeaderPadding) ||
+		!bytes.Equal(o.RingSnapshot, p.RingSnapshot) {
+		return false
+	}
+	for i, b := range o.RecordBoundaries {
+		if b != p.RecordBoundaries[i] {
+			return false
+		}
+	}
+	// every other exported field, in declaration order
+	return o.MaxDistance == p.MaxDistance &&
+		o.Alignment == p.Alignment &&
+		o.LengthFooter == p.LengthFooter &&
+		o.LiteralsOnly == p.LiteralsOnly &&
+		o.Metrics == p.Metrics &&
+		o.Packing == p.Packing &&
+		o.InitialCapacity == p.InitialCapacity &&
+		o.Parsing == p.Parsing &&
+		o.UncompressedSize == p.UncompressedSize &&
+		o.InvertFlags == p.InvertFlags &&
+		o.EndMarker == p.EndMarker &&
+		o.LiteralRuns == p.LiteralRuns &&
+		o.Strict == p.Strict &&
+		o.Logger == p.Logger &&
+		o.MaxOutput == p.MaxOutput &&
+		o.MinMatch == p.MinMatch &&
+		o.ZeroPadding == p.ZeroPadding &&
+		o.FlushGroups == p.FlushGroups
+}
+
+// WithMaxDistance prevents the encoder from emitting matches farther back than d
+// bytes. Longer-distance matches are sent as literals instead, so the output
+// stays a standard stream that decoders with a limited lookback can handle.
+func WithMaxDistance(d int) Option {
+	return func(o *Options) {
+		o.MaxDistance = d
+	}
+}
+
+// WithOutputAlignment pads the compressed output with zero bytes so its length
+// is a multiple of a. The padding is not part of the LZSS stream: decoders
+// without the option read the zeros as match tokens. Decoders given the same
+// WithOutputAlignment stop where fewer than a zero bytes are left and the
+// next token would be a match or a new flag byte, as WithZeroPadding does for
+// any run of zeros. Streaming decoders size their input buffer to peek that
+// far ahead, but an io.ByteReader given to DecompressFunc must be able to
+// Peek itself. Otherwise decode the data with its true compressed length,
+// for example the CompressedSize of a Header. So that the
+// stream itself never ends in such zeros, the encoder sends a three byte
+// match from ring position 0 as literals, and ends a stream whose last group
+// is all zero bytes with the WithEndMarker marker, which these decoders
+// always honor.
+func WithOutputAlignment(a int) Option {
+	return func(o *Options) {
+		o.Alignment = a
+	}
+}
+
+// WithLengthFooter appends the uncompressed length as a 4-byte big-endian footer
+// after the compressed data and any alignment padding, so it is always the
+// last 4 bytes and a decoder can preallocate its output. Decoders given it strip the footer and fail if it does
+// not match the decoded length; DecompressWithFooter implies it.
+func WithLengthFooter(enable bool) Option {
+	return func(o *Options) {
+		o.LengthFooter = enable
+	}
+}
+
+// WithLiteralsOnly makes the encoder send every byte as a literal. The output is
+// a valid stream of about 9/8 the input size, which is useful for telling
+// decoder framing bugs apart from match-finding bugs.
+func WithLiteralsOnly(enable bool) Option {
+	return func(o *Options) {
+		o.LiteralsOnly = enable
+	}
+}
+
+// WithMetrics records bytes in and out, flag-byte groups and time spent for each
+// Compress or Decompress call in m. Without it no metrics work is done at all.
+func WithMetrics(m *Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = m
+	}
+}
+
+// WithPacking selects the nibble order of match tokens, for reading or writing
+// files from LZSS variants that pack the position and length the other way.
+func WithPacking(p Packing) Option {
+	return func(o *Options) {
+		o.Packing = p
+	}
+}
+
+// WithRecordBoundaries stops the encoder from emitting matches that reach back
+// across, or extend over, any of the given input offsets. No record then
+// depends on bytes before its start, at some cost in ratio. The output is a
+// standard stream; a decoder starting at a record still needs that record's
+// compressed offset and flag byte state, e.g. from a TokenReader pass.
+func WithRecordBoundaries(offsets []int) Option {
+	return func(o *Options) {
+		o.RecordBoundaries = append([]int(nil), offsets...)
+		sort.Ints(o.RecordBoundaries)
+	}
+}
+
+// WithInitialCapacity pre-sizes the output buffer of Compress or Decompress to
+// c bytes, for callers that know roughly how big the output will be.
+func WithInitialCapacity(c int) Option {
+	return func(o *Options) {
+		o.InitialCapacity = c
+	}
+}
+
+// peekSize returns how many bytes a streaming decoder must be able to peek
+// ahead to find WithOutputAlignment padding, and the footer after it
+func (o Options) peekSize() int {
+	if o.Alignment <= 1 {
+		return 0
+	}
+	if o.LengthFooter {
+		return o.Alignment + footerSize
+	}
+	return o.Alignment
+}
+
+// capacity returns the initial output buffer size, falling back to guess
+func (o Options) capacity(guess int) int {
+	if o.InitialCapacity > 0 {
+		return o.InitialCapacity
+	}
+	return guess
+}
+
+// WithParsing selects how the encoder chooses between literals and matches.
+// Every strategy produces a standard stream, they only trade speed for ratio.
+func WithParsing(p Parsing) Option {
+	return func(o *Options) {
+		o.Parsing = p
+	}
+}
+
+// WithHeaderPadding sets the padding bytes of the Header written by CompressFile
+// and CompressFileStream, for reproducing files whose padding is not all zeros.
+// Decoders ignore the padding.
+func WithHeaderPadding(p []byte) Option {
+	return func(o *Options) {
+		o.HeaderPadding = append([]byte(nil), p...)
+	}
+}
+
+// WithMaxOutput makes DecompressTo and DecompressStream fail with
+// ErrOutputTooLarge rather than write more than max decoded bytes, so
+// untrusted data can be decoded straight to a file or socket.
+func WithMaxOutput(max int64) Option {
+	return func(o *Options) {
+		o.MaxOutput = max
+	}
+}
+
+// WithInvertedFlags flips the meaning of flag bits, so a 1 bit is a match and
+// a 0 bit a literal, for LZSS variants that use that polarity. It applies to
+// both the encoder and the decoder.
+func WithInvertedFlags(enable bool) Option {
+	return func(o *Options) {
+		o.InvertFlags = enable
+	}
+}
+
+// WithLogger logs every literal and match the encoder sends to l, with its
+// input offset and, for matches, the distance and length. It is meant for
+// debugging the encoder and is very verbose. Without it nothing is logged.
+func WithLogger(l *log.Logger) Option {
+	return func(o *Options) {
+		o.Logger = l
+	}
+}
+
+// WithStrict makes decoders fail with ErrNonCanonical on tokens a conforming
+// encoder never emits: a match that copies from the position it is writing,
+// or one reaching back before the start of the output by more than the f
+// bytes of ring fill an Okumura encoder may match against. Matches shorter
+// than threshold+1 cannot occur, as the length nibble cannot encode them.
+// The default is to decode such tokens anyway. Decompress cannot return the
+// error and just stops there, so use an error-returning decoder such as
+// DecompressTo.
+func WithStrict(enable bool) Option {
+	return func(o *Options) {
+		o.Strict = enable
+	}
+}
+
+// WithEndMarker ends the compressed stream with an explicit marker, so a
+// decoder knows where it ends even when it is followed by other data. The
+// marker is a match of length field 0 copying from the position it writes
+// to, which no encoder otherwise sends. Decoders given WithEndMarker stop at
+// it and ignore the rest of their input; others decode it as three bytes of
+// garbage.
+func WithEndMarker(enable bool) Option {
+	return func(o *Options) {
+		o.EndMarker = enable
+	}
+}
+
+// WithUncompressedSize records size as the UncompressedSize of the Header
+// written by CompressFile and CompressFileStream, for loaders that expect a
+// padded or aligned size there. Sizes below the actual input length are
+// ignored. DecompressFile accepts such files: it only requires the decoded
+// data to be no longer than the header says and to match its checksum.
+func WithUncompressedSize(size int64) Option {
+	return func(o *Options) {
+		o.UncompressedSize = size
+	}
+}
+
+// WithMinMatch stops the encoder from sending matches shorter than m bytes,
+// which are sent as literals instead. A match costs two bytes plus a flag bit,
+// so a three byte match only saves seven bits over literals; whether skipping
+// such marginal matches pays off depends on the data, and on ordinary text it
+// usually costs ratio. m must be between threshold+1 (3, the default) and f
+// (18). The output is a standard stream.
+func WithMinMatch(m int) Option {
+	return func(o *Options) {
+		o.MinMatch = m
+	}
+}
+
+// WithZeroPadding makes decoders of in-memory data, such as Decompress and
+// DecompressTo, stop where the input has only zero bytes left and the next
+// token would be a match or start a new flag byte group. Payloads padded with
+// zeros to an alignment then decode without the zeros turning into garbage
+// matches. This is a heuristic: a genuine stream ending in matches that encode
+// as zeros, copying from the start of the ring, loses them. DecompressFile
+// needs no such help, as it stops at the header's CompressedSize.
+func WithZeroPadding(enable bool) Option {
+	return func(o *Options) {
+		o.ZeroPadding = enable
+	}
+}
+
+// WithRingSnapshot seeds the ring buffer with ring, the up to n-f (4078)
+// bytes that logically precede the input, such as the end of a previous file
+// or of the earlier part of a stream compressed elsewhere. Matches may then
+// reference it like a preset dictionary. The decoder must be given the same
+// snapshot, or those matches decode to garbage; without a snapshot the ring
+// starts out filled with spaces that the encoder never references.
+func WithRingSnapshot(ring []byte) Option {
+	return func(o *Options) {
+		o.RingSnapshot = append([]byte(nil), ring...)
+	}
+}
+
+// WithGroupFlush makes CompressStream write every flag byte group to its
+// destination as soon as the group is complete, instead of buffering output
+// into larger writes, so a network peer sees data with little delay. The
+// groups and so the compressed bytes are the same either way; only the number
+// of writes changes. A group still waits for its eight tokens, and a token for
+// up to f bytes of lookahead, until the input ends.
+func WithGroupFlush(enable bool) Option {
+	return func(o *Options) {
+		o.FlushGroups = enable
+	}
+}
+
+// WithLiteralRuns enables a format extension that sends 26 or more literals
+// in a row as one run token instead of flagging each: a match copying from
+// its own position with the length field set to 1, then a byte holding the
+// run length minus one and up to 256 literal bytes. Random data then expands
+// by about 1% instead of 12.5%. Streams written with it are not standard and
+// must be decoded with WithLiteralRuns too; the default format is unchanged.
+func WithLiteralRuns(enable bool) Option {
+	return func(o *Options) {
+		o.LiteralRuns = enable
+	}
+}
@@ -0,0 +1,119 @@
+package lzss
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		err  string // substring of the error, empty if the options are valid
+	}{
+		{"defaults", nil, ""},
+		{"full window", []Option{WithMaxDistance(n)}, ""},
+		{"aligned footer", []Option{WithOutputAlignment(16), WithLengthFooter(true)}, ""},
+		{"min match bounds", []Option{WithMinMatch(threshold + 1)}, ""},
+		{"longest min match", []Option{WithMinMatch(f), WithParsing(Optimal)}, ""},
+		{"length high inverted", []Option{WithPacking(PackLengthHigh), WithInvertedFlags(true)}, ""},
+		{"full snapshot", []Option{WithRingSnapshot(make([]byte, n-f))}, ""},
+		{"full header padding", []Option{WithHeaderPadding(make([]byte, padding))}, ""},
+		{"unsorted boundaries", []Option{WithRecordBoundaries([]int{30, 10, 20})}, ""},
+		{"largest header size", []Option{WithUncompressedSize(1<<32 - 1)}, ""},
+
+		{"negative distance", []Option{WithMaxDistance(-1)}, "max distance"},
+		{"distance past window", []Option{WithMaxDistance(n + 1)}, "max distance"},
+		{"negative alignment", []Option{WithOutputAlignment(-1)}, "alignment"},
+		{"negative capacity", []Option{WithInitialCapacity(-1)}, "initial capacity"},
+		{"negative size", []Option{WithUncompressedSize(-1)}, "uncompressed size"},
+		{"size past header", []Option{WithUncompressedSize(1 << 32)}, "uncompressed size"},
+		{"min match too short", []Option{WithMinMatch(threshold)}, "min match"},
+		{"min match too long", []Option{WithMinMatch(f + 1)}, "min match"},
+		{"negative max output", []Option{WithMaxOutput(-1)}, "max output"},
+		{"unknown packing", []Option{WithPacking(Packing(99))}, "packing"},
+		{"unknown parsing", []Option{WithParsing(Parsing(99))}, "parsing"},
+		{"snapshot too long", []Option{WithRingSnapshot(make([]byte, n-f+1))}, "ring snapshot"},
+		{"header padding too long", []Option{WithHeaderPadding(make([]byte, padding+1))}, "header padding"},
+		{"negative boundary", []Option{WithRecordBoundaries([]int{-1, 10})}, "record boundary"},
+		{"boundaries set unsorted", []Option{func(o *Options) { o.RecordBoundaries = []int{20, 10} }}, "not sorted"},
+	}
+	for _, tt := range tests {
+		err := newOptions(tt.opts).Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: no error, want one containing %q", tt.name, tt.err)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: error %q, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// optionGrid returns every combination of a representative value of each
+// option that changes the compressed format or the encoder's choices
+func optionGrid() [][]Option {
+	grid := [][]Option{nil}
+	axes := [][]Option{
+		{WithParsing(Greedy), WithParsing(Lazy), WithParsing(Optimal)},
+		{WithPacking(PackPositionHigh), WithPacking(PackLengthHigh)},
+		{WithInvertedFlags(false), WithInvertedFlags(true)},
+		{WithLiteralRuns(false), WithLiteralRuns(true)},
+		{WithMinMatch(0), WithMinMatch(5)},
+		{WithMaxDistance(0), WithMaxDistance(100)},
+		{WithEndMarker(false), WithEndMarker(true)},
+		{WithLengthFooter(false), WithLengthFooter(true)},
+		{WithOutputAlignment(0), WithOutputAlignment(16)},
+		{WithZeroPadding(false), WithZeroPadding(true)},
+		{WithRingSnapshot(nil), WithRingSnapshot([]byte("hello world abcdefgh  \n"))},
+		{WithRecordBoundaries(nil), WithRecordBoundaries([]int{1, 50, 1000})},
+	}
+	for _, axis := range axes {
+		var next [][]Option
+		for _, opts := range grid {
+			for _, opt := range axis {
+				next = append(next, append(opts[:len(opts):len(opts)], opt))
+			}
+		}
+		grid = next
+	}
+	return grid
+}
+
+func TestOptionMatrix(t *testing.T) {
+	// to keep the grid fast, cut long inputs to two windows and round trip
+	// one long and one short input per combination, rotating through them:
+	// each compress call sets up a new encoder. Odd counts of both keep the
+	// rotation from lining up with the two-valued axes.
+	var long, short [][]byte
+	for i, src := range corpus() {
+		if len(src) > 2*n {
+			src = src[:2*n]
+		}
+		if len(src) >= 100 {
+			long = append(long, src)
+		} else if i%7 == 0 {
+			short = append(short, src)
+		}
+	}
+	for i, opts := range optionGrid() {
+		o := newOptions(opts)
+		// decode with the encoder's options, and strictly, so a
+		// combination that emits tokens its own decoder rejects fails too
+		dec := append(opts[:len(opts):len(opts)], WithStrict(true))
+		for _, src := range [][]byte{long[i%len(long)], short[i%len(short)]} {
+			dst := Compress(src, opts...)
+			var buf bytes.Buffer
+			if _, err := DecompressTo(&buf, dst, dec...); err != nil || !bytes.Equal(buf.Bytes(), src) {
+				t.Fatalf("options %d %+v: DecompressTo of %d bytes returned %d: %v", i, o, len(src), buf.Len(), err)
+			}
+			got, err := ioutil.ReadAll(NewReader(bytes.NewReader(dst), dec...))
+			if err != nil || !bytes.Equal(got, src) {
+				t.Fatalf("options %d %+v: NewReader of %d bytes returned %d: %v", i, o, len(src), len(got), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package lzss
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// minReaderSize is the smallest input buffer NewReaderSize uses
+const minReaderSize = 16
+
+type reader struct {
+	d   *decoder
+	err error
+}
+
+// NewReader returns a reader that decompresses the lzss data read from r,
+// decoding only as much as each Read needs.
+func NewReader(r io.Reader, opts ...Option) io.Reader {
+	return NewReaderSize(r, 0, opts...)
+}
+
+// NewReaderSize is NewReader with an input buffer of bufSize bytes. Smaller
+// buffers use less memory at the cost of more Read calls on r; sizes below
+// 16 bytes are raised to 16, or to fit the look-ahead WithOutputAlignment
+// needs. The decoder's ring buffer is always needed on top of it, see
+// ScratchSize.
+func NewReaderSize(r io.Reader, bufSize int, opts ...Option) io.Reader {
+	return newReader(r, bufSize, mustOptions(opts))
+}
+
+func newReader(r io.Reader, bufSize int, o Options) *reader {
+	if bufSize < minReaderSize {
+		bufSize = minReaderSize
+	}
+	if bufSize < o.peekSize() {
+		bufSize = o.peekSize()
+	}
+	d := newDecoderBuf(bufio.NewReaderSize(r, bufSize), nil)
+	d.setOptions(o)
+	return &reader{d: d}
+}
+
+func (z *reader) Read(p []byte) (int, error) {
+	var k int
+	for k < len(p) && z.err == nil {
+		if len(z.d.pending) == 0 {
+			tok, err := z.d.next()
+			if err != nil {
+				z.err = err
+				break
+			}
+			z.d.pending = tok
+		}
+		c := copy(p[k:], z.d.pending)
+		z.d.pending = z.d.pending[c:]
+		k += c
+	}
+	if k > 0 {
+		return k, nil
+	}
+	return 0, z.err
+}
+
+type autoReader struct {
+	br  *bufio.Reader
+	r   io.Reader
+	err error
+}
+
+// NewAutoReader returns a reader that decompresses r if it starts with a
+// complzss Header and otherwise passes the bytes of r through unchanged. The
+// detection only peeks at r, so nothing is lost on the passthrough path.
+func NewAutoReader(r io.Reader) io.Reader {
+	return &autoReader{br: bufio.NewReader(r)}
+}
+
+func (a *autoReader) Read(p []byte) (int, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.r == nil {
+		magic, _ := a.br.Peek(8)
+		if !hasHeaderMagic(magic) {
+			a.r = a.br
+		} else {
+			dat, err := readContainer(a.br)
+			if err != nil {
+				a.err = err
+				return 0, err
+			}
+			a.r = bytes.NewReader(dat)
+		}
+	}
+	return a.r.Read(p)
+}
+
+// readContainer reads a complzss Header and its payload from r and returns the
+// decompressed data.
+func readContainer(r io.Reader) ([]byte, error) {
+	raw := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", noEOF(err))
+	}
+	var hdr Header
+	if err := hdr.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+
+	src, err := ioutil.ReadAll(io.LimitReader(r, int64(hdr.CompressedSize)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed data: %w", err)
+	}
+	if len(src) != int(hdr.CompressedSize) {
+		return nil, fmt.Errorf("failed to read compressed data: %w", io.ErrUnexpectedEOF)
+	}
+
+	return decompressContainer(&hdr, src)
+}
@@ -0,0 +1,365 @@
+package lzss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNonCanonical is returned by strict decoders for tokens a conforming
+// encoder never emits; see WithStrict.
+var ErrNonCanonical = errors.New("non-canonical token")
+
+// DecodeError reports where in the compressed data decoding failed. It wraps
+// the underlying error, such as io.ErrUnexpectedEOF for a truncated stream.
+type DecodeError struct {
+	Offset     int    // offset in the compressed data of the token that failed
+	TokenIndex int    // number of tokens decoded before it
+	Kind       string // "flag", "literal", "match", "literal run" or "footer"
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s token %d at offset %d: %v", e.Kind, e.TokenIndex, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TokenReader iterates over the literal and match tokens of lzss data without
+// decoding them, which is much cheaper than a full decompress for inspecting
+// how data was compressed.
+type TokenReader struct {
+	r       io.ByteReader
+	pos     int // bytes read from r
+	flags   uint
+	groups  int // flag bytes read so far
+	offset  int // offset in the decompressed output
+	packing Packing
+	invert  bool          // flag bytes are inverted
+	strict  bool          // reject non-canonical matches
+	marker  bool          // stop at an end of stream marker
+	ended   bool          // the end of stream marker or padding was reached
+	padded  bool          // decoding stopped at padding
+	runs    bool          // decode literal runs, see WithLiteralRuns
+	run     int           // literals left in the current run
+	tokens  int           // tokens returned so far
+	zeroPad bool          // stop at trailing zero padding, see WithZeroPadding
+	align   int           // stop at zero padding shorter than this, see WithOutputAlignment
+	src     []byte        // the input, when it is held in memory
+	padAt   int           // input offset where trailing zero bytes start, -1 if unknown
+	size    int           // input length before any footer, when padAt is known
+	history int           // bytes of WithRingSnapshot before the output
+	footer  *footerReader // holds back the WithLengthFooter footer, nil without one
+}
+
+// NewTokenReader returns a TokenReader over src. Only the WithPacking,
+// WithInvertedFlags, WithStrict, WithEndMarker, WithLiteralRuns,
+// WithZeroPadding, WithOutputAlignment and WithLengthFooter options affect how
+// tokens are parsed.
+func NewTokenReader(src []byte, opts ...Option) *TokenReader {
+	t := &TokenReader{r: bytes.NewReader(src), src: src}
+	t.setOptions(mustOptions(opts))
+	return t
+}
+
+// zeroTail returns the offset of the run of zero bytes that src ends with
+func zeroTail(src []byte) int {
+	i := len(src)
+	for i > 0 && src[i-1] == 0 {
+		i--
+	}
+	return i
+}
+
+// setOptions applies the options that affect how tokens are parsed
+func (t *TokenReader) setOptions(o Options) {
+	t.packing = o.Packing
+	t.invert = o.InvertFlags
+	t.strict = o.Strict
+	t.marker = o.EndMarker || o.Alignment > 1
+	t.runs = o.LiteralRuns
+	t.zeroPad = o.ZeroPadding
+	t.align = o.Alignment
+	t.history = len(o.RingSnapshot)
+	if o.LengthFooter {
+		t.footer = &footerReader{r: t.r}
+		t.r = t.footer
+	}
+	t.padAt = -1
+	if t.src != nil {
+		// padding goes before a footer
+		body := t.src
+		if t.footer != nil && len(body) >= footerSize {
+			body = body[:len(body)-footerSize]
+		}
+		t.padAt, t.size = zeroTail(body), len(body)
+	}
+}
+
+// footerReader reads through r but holds back the last footerSize bytes, so
+// a WithLengthFooter footer is never decoded as tokens.
+type footerReader struct {
+	r    io.ByteReader
+	buf  [footerSize]byte
+	held int  // bytes of buf filled
+	done bool // the footer was checked
+}
+
+func (fr *footerReader) ReadByte() (byte, error) {
+	for fr.held < footerSize {
+		c, err := fr.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		fr.buf[fr.held] = c
+		fr.held++
+	}
+	c, err := fr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	out := fr.buf[0]
+	copy(fr.buf[:], fr.buf[1:])
+	fr.buf[footerSize-1] = c
+	return out, nil
+}
+
+// Peek returns the next k bytes before the footer, for finding alignment
+// padding in a stream. Like bufio.Reader.Peek it returns fewer bytes and
+// io.EOF when the input ends sooner.
+func (fr *footerReader) Peek(k int) ([]byte, error) {
+	p, ok := fr.r.(interface{ Peek(int) ([]byte, error) })
+	if !ok {
+		return nil, errors.New("input cannot peek")
+	}
+	ahead, err := p.Peek(k + footerSize - fr.held)
+	buf := append(fr.buf[:fr.held:fr.held], ahead...)
+	if err == nil {
+		return buf[:k], nil
+	}
+	if len(buf) < footerSize {
+		return nil, err
+	}
+	return buf[:len(buf)-footerSize], err
+}
+
+// check compares the held back footer with the number of bytes decoded,
+// first skipping the padding before it if there may be any.
+func (fr *footerReader) check(decoded int, skip bool) error {
+	fr.done = true
+	for skip {
+		if _, err := fr.ReadByte(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	for fr.held < footerSize {
+		c, err := fr.r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("data too short for a %d byte length footer: %w", footerSize, io.ErrUnexpectedEOF)
+		}
+		fr.buf[fr.held] = c
+		fr.held++
+	}
+	if size := int(binary.BigEndian.Uint32(fr.buf[:])); size != decoded {
+		return fmt.Errorf("decoded %d bytes, footer says %d", decoded, size)
+	}
+	return nil
+}
+
+// atPadding reports whether only zero padding is left of the input: any
+// trailing zeros with WithZeroPadding, or fewer zeros than the alignment with
+// WithOutputAlignment. For a stream only the latter can be checked, by peeking
+// ahead when it is buffered. Any length footer follows the padding.
+func (t *TokenReader) atPadding() bool {
+	if !t.zeroPad && t.align <= 1 {
+		return false
+	}
+	if t.padAt >= 0 {
+		return t.pos >= t.padAt && (t.zeroPad || t.size-t.pos < t.align)
+	}
+	p, ok := t.r.(interface{ Peek(int) ([]byte, error) })
+	if !ok || t.align <= 1 {
+		return false
+	}
+	rest, err := p.Peek(t.align)
+	return err == io.EOF && zeroTail(rest) == 0
+}
+
+func (t *TokenReader) readByte() (int, error) {
+	c, err := t.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	t.pos++
+	return int(c), nil
+}
+
+// Next returns the next token. Event.Offset is the offset in the decompressed
+// output the token expands to. Next returns io.EOF when src is exhausted on a
+// token boundary or at an end marker (see WithEndMarker). Any other error is
+// a *DecodeError, wrapping io.ErrUnexpectedEOF when a match is cut short. With
+// WithLengthFooter the footer is checked against the decoded length before
+// io.EOF is returned.
+func (t *TokenReader) Next() (Event, error) {
+	start := t.pos
+	ev, kind, err := t.token()
+	if err == io.EOF && t.footer != nil && !t.footer.done {
+		// in an aligned stream padding may follow an end marker too
+		kind, err = "footer", t.footer.check(t.offset, t.padded || t.align > 1)
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	if err == io.EOF {
+		return Event{}, err
+	} else if err != nil {
+		return Event{}, &DecodeError{Offset: start, TokenIndex: t.tokens, Kind: kind, Err: err}
+	}
+	t.tokens++
+	return ev, nil
+}
+
+// token reads the next token and also returns what kind of token it was
+// reading, for errors.
+func (t *TokenReader) token() (Event, string, error) {
+	if t.ended {
+		return Event{}, "", io.EOF
+	}
+	if t.run > 0 {
+		// a run's literals are not flagged
+		c, err := t.readByte()
+		if err != nil {
+			return Event{}, "literal run", noEOF(err)
+		}
+		t.run--
+		ev := Event{Offset: t.offset, Literal: byte(c)}
+		t.offset++
+		return ev, "", nil
+	}
+	// only padding left, and the next token is a match or in a new group:
+	// a stream padded with zeros just reads as more matches from here
+	padding := t.atPadding()
+	t.flags = t.flags >> 1
+	if padding && (t.flags&0x100 == 0 || t.flags&1 == 0) {
+		t.ended, t.padded = true, true
+		return Event{}, "", io.EOF
+	}
+	if ((t.flags) & 0x100) == 0 {
+		c, err := t.readByte()
+		if err != nil {
+			return Event{}, "flag", err
+		}
+		if t.invert {
+			c ^= 0xFF
+		}
+		t.flags = uint(c | 0xFF00) /* uses higher byte cleverly to count eight*/
+		t.groups++
+	}
+	if t.flags&1 == 1 {
+		c, err := t.readByte()
+		if err != nil {
+			return Event{}, "literal", err
+		}
+		ev := Event{Offset: t.offset, Literal: byte(c)}
+		t.offset++
+		return ev, "", nil
+	}
+
+	i, err := t.readByte()
+	if err != nil {
+		return Event{}, "match", err
+	}
+	j, err := t.readByte()
+	if err != nil {
+		return Event{}, "match", noEOF(err)
+	}
+
+	if t.packing == PackLengthHigh {
+		i |= ((j & 0x0F) << 8)
+		j = (j >> 4) + threshold
+	} else {
+		i |= ((j & 0xF0) << 4)
+		j = (j & 0x0F) + threshold
+	}
+	ev := Event{Offset: t.offset, Match: Match{Position: i, Length: j + 1}}
+	if ev.Distance() == 0 {
+		switch {
+		case t.marker && ev.Match.Length == threshold+1:
+			t.ended = true
+			return Event{}, "", io.EOF
+		case t.runs && ev.Match.Length == threshold+2:
+			c, err := t.readByte()
+			if err != nil {
+				return Event{}, "literal run", noEOF(err)
+			}
+			t.run = c + 1
+			return t.token()
+		}
+	}
+	if t.strict && !ev.canonical(t.history) {
+		return Event{}, "match", fmt.Errorf("match at output offset %d reaching %d bytes back: %w", ev.Offset, ev.Distance(), ErrNonCanonical)
+	}
+	t.offset += j + 1
+	return ev, "", nil
+}
+
+// canonical reports whether a conforming encoder could have sent the match e:
+// it must not copy from the position being written, nor reach back before the
+// start of the output by more than the f bytes of ring fill or the history
+// bytes of a ring snapshot, whichever is more.
+func (e Event) canonical(history int) bool {
+	if history < f {
+		history = f
+	}
+	dist := e.Distance()
+	return dist != 0 && dist <= e.Offset+history
+}
+
+// HasMatches reports whether src contains any match token, i.e. whether it
+// compressed at all rather than being stored as literals. It stops at the
+// first match found.
+func HasMatches(src []byte) bool {
+	t := NewTokenReader(src)
+	for {
+		ev, err := t.Next()
+		if err != nil {
+			return false
+		}
+		if ev.IsMatch() {
+			return true
+		}
+	}
+}
+
+// MaxDistanceUsed returns the largest distance any match in src reaches back,
+// or 0 when it has no matches. Data of the same kind compressed
+// WithMaxDistance set to about this value loses little ratio, and can then be
+// decoded with a smaller window. opts select the token format, as for
+// NewTokenReader.
+func MaxDistanceUsed(src []byte, opts ...Option) (int, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	t := &TokenReader{r: bytes.NewReader(src), src: src}
+	t.setOptions(o)
+
+	max := 0
+	for {
+		ev, err := t.Next()
+		if err == io.EOF {
+			return max, nil
+		} else if err != nil {
+			return max, err
+		}
+		if ev.IsMatch() && ev.Distance() > max {
+			max = ev.Distance()
+		}
+	}
+}
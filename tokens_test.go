@@ -0,0 +1,74 @@
+package lzss
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// readTokens returns every token of src, failing t on a decode error
+func readTokens(t *testing.T, src []byte, opts ...Option) []Event {
+	t.Helper()
+	var events []Event
+	tr := NewTokenReader(src, opts...)
+	for {
+		ev, err := tr.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestOverlappingMatches(t *testing.T) {
+	src := bytes.Repeat([]byte{0xAB}, 300)
+	dst := Compress(src)
+
+	// after the first literal each full match copies from the byte just
+	// written, so it reads bytes it is producing itself; the short last
+	// match may come from anywhere in the run
+	events := readTokens(t, dst)
+	if len(events) < 2 || events[0].IsMatch() || events[0].Literal != 0xAB {
+		t.Fatalf("first token is not the literal 0xAB: %+v", events)
+	}
+	for i, ev := range events[1 : len(events)-1] {
+		if !ev.IsMatch() || ev.Distance() != 1 || ev.Match.Length <= ev.Distance() {
+			t.Errorf("token %d is %+v, want an overlapping distance 1 match", i+1, ev)
+		}
+	}
+	if ev := events[len(events)-1]; !ev.IsMatch() || ev.Offset+ev.Match.Length != len(src) {
+		t.Errorf("last token is %+v, want a match ending at %d", ev, len(src))
+	}
+	if got := Decompress(dst); !bytes.Equal(got, src) {
+		t.Errorf("round trip returned %d bytes, want %d", len(got), len(src))
+	}
+}
+
+func TestFarthestMatch(t *testing.T) {
+	// random bytes, except that 40 bytes come back exactly n-f bytes after
+	// they first appeared, the farthest a match can reach in the ring
+	rnd := rand.New(rand.NewSource(7))
+	src := make([]byte, 8000)
+	rnd.Read(src)
+	copy(src[n-f:], src[:40])
+	dst := Compress(src)
+
+	far := false
+	for _, ev := range readTokens(t, dst) {
+		if d := ev.Distance(); d > n-f {
+			t.Fatalf("match %+v reaches %d bytes back, past the %d the ring holds", ev, d, n-f)
+		} else if d == n-f && ev.Offset == n-f {
+			far = true
+		}
+	}
+	if !far {
+		t.Errorf("no match from offset %d reaching %d bytes back", n-f, n-f)
+	}
+	if got := Decompress(dst); !bytes.Equal(got, src) {
+		t.Errorf("round trip returned %d bytes, want %d", len(got), len(src))
+	}
+}
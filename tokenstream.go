@@ -0,0 +1,95 @@
+package lzss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// tokenStreamMagic starts every token stream and carries its version in the last byte
+var tokenStreamMagic = [4]byte{'L', 'Z', 'T', 1}
+
+const (
+	// literalTokenSize is the payload of a literal token: the byte itself
+	literalTokenSize = 1
+	// matchTokenSize is the payload of a match token: a big-endian uint16
+	// distance followed by the length
+	matchTokenSize = 3
+)
+
+// CompressTokens compresses src like Compress but writes the tokens in a
+// separate, self-delimiting format rather than as a standard lzss stream:
+//
+//	"LZT" version(1)
+//	[1][byte]                     literal
+//	[3][distance uint16][length]  match
+//
+// Every token starts with its payload size, so a receiver can handle tokens as
+// they arrive without reassembling flag byte groups. The output is larger than
+// Compress's and can only be read by DecompressTokens, which must be given the
+// same WithRingSnapshot, if any.
+func CompressTokens(src []byte, opts ...Option) []byte {
+	var events []Event
+	// neither side of an in-memory compress can fail
+	compress(ioutil.Discard, bytes.NewReader(src), mustOptions(opts), nil, &events)
+
+	dst := make([]byte, 0, len(tokenStreamMagic)+len(events)*2)
+	dst = append(dst, tokenStreamMagic[:]...)
+	for _, ev := range events {
+		if !ev.IsMatch() {
+			dst = append(dst, literalTokenSize, ev.Literal)
+			continue
+		}
+		dst = append(dst, matchTokenSize, byte(ev.Distance()>>8), byte(ev.Distance()), byte(ev.Match.Length))
+	}
+	return dst
+}
+
+// DecompressTokens decodes data written by CompressTokens. Of the options only
+// WithRingSnapshot applies: matches may reach back into the snapshot.
+func DecompressTokens(src []byte, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if len(src) < len(tokenStreamMagic) || !bytes.Equal(src[:3], tokenStreamMagic[:3]) {
+		return nil, errors.New("missing token stream magic")
+	}
+	if v := src[3]; v != tokenStreamMagic[3] {
+		return nil, fmt.Errorf("unsupported token stream version %d", v)
+	}
+
+	// the snapshot is history in front of the output
+	history := len(o.RingSnapshot)
+	dst := append([]byte(nil), o.RingSnapshot...)
+	for pos := len(tokenStreamMagic); pos < len(src); {
+		size := int(src[pos])
+		pos++
+		if len(src)-pos < size {
+			return nil, fmt.Errorf("token at %d needs %d bytes, %d left", pos-1, size, len(src)-pos)
+		}
+		tok := src[pos : pos+size]
+		pos += size
+
+		switch size {
+		case literalTokenSize:
+			dst = append(dst, tok[0])
+		case matchTokenSize:
+			dist := int(binary.BigEndian.Uint16(tok))
+			length := int(tok[2])
+			if dist == 0 || dist > len(dst) {
+				return nil, fmt.Errorf("match at output offset %d reaches %d bytes back", len(dst)-history, dist)
+			}
+			// copy byte by byte, as a match may overlap its own output
+			for k := 0; k < length; k++ {
+				dst = append(dst, dst[len(dst)-dist])
+			}
+		default:
+			return nil, fmt.Errorf("unknown token size %d at %d", size, pos-size-1)
+		}
+	}
+
+	return dst[history:], nil
+}
@@ -0,0 +1,38 @@
+package lzss
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTokenStreamRoundTrip(t *testing.T) {
+	for _, src := range corpus() {
+		got, err := DecompressTokens(CompressTokens(src))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(src), err)
+		}
+		if !bytes.Equal(got, src) {
+			t.Fatalf("%d bytes did not round trip", len(src))
+		}
+	}
+}
+
+func TestTokenStreamRingSnapshot(t *testing.T) {
+	snapshot := bytes.Repeat([]byte("the quick brown fox "), 50)
+	src := []byte("the quick brown fox jumps over the lazy dog")
+
+	dst := CompressTokens(src, WithRingSnapshot(snapshot))
+	if plain := CompressTokens(src); len(dst) >= len(plain) {
+		t.Errorf("%d bytes with the snapshot, want fewer than the %d without", len(dst), len(plain))
+	}
+	got, err := DecompressTokens(dst, WithRingSnapshot(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Errorf("got %q, want %q", got, src)
+	}
+	if _, err := DecompressTokens(dst); err == nil {
+		t.Error("DecompressTokens without the snapshot accepted matches into it")
+	}
+}